@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// configEntry is one key of a config file with its values, more than one
+// only for an array
+type configEntry struct {
+	line   int
+	key    string
+	values []string
+}
+
+// applyConfig sets the flags named by the keys of the config file at path,
+// skipping flags already set on the command line so those take precedence
+func applyConfig(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := parseConfig(bufio.NewScanner(file))
+	if err != nil {
+		return fmt.Errorf("%s:%w", path, err)
+	}
+
+	// Flags given on the command line, before the config sets any
+	onCommandLine := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+
+	for _, entry := range entries {
+		f := flag.Lookup(entry.key)
+		if f == nil || entry.key == "config" || entry.key == "version" {
+			return fmt.Errorf("%s:%d: unknown key %q", path, entry.line, entry.key)
+		}
+		if _, isList := f.Value.(*stringList); !isList && len(entry.values) != 1 {
+			return fmt.Errorf("%s:%d: %s takes a single value, not an array", path, entry.line, entry.key)
+		}
+		if onCommandLine[entry.key] {
+			continue
+		}
+		for _, value := range entry.values {
+			if err := flag.Set(entry.key, value); err != nil {
+				return fmt.Errorf("%s:%d: invalid value %q for %s: %v", path, entry.line, value, entry.key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// parseConfig reads the subset of TOML a config file is written in: one
+// key = value per line, where the value is a string, a number, true or false,
+// or a single line array of those. Comments start with #. Errors are
+// prefixed with the line number.
+func parseConfig(scanner *bufio.Scanner) ([]configEntry, error) {
+	var entries []configEntry
+	seen := map[string]bool{}
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			return nil, fmt.Errorf("%d: tables are not supported, set every key at the top level", line)
+		}
+
+		key, rest, found := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("%d: expected key = value", line)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%d: key %q appears more than once", line, key)
+		}
+		seen[key] = true
+
+		values, err := parseConfigValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s: %v", line, key, err)
+		}
+		entries = append(entries, configEntry{line: line, key: key, values: values})
+	}
+	return entries, scanner.Err()
+}
+
+// parseConfigValue parses the value of a config line, returning the
+// elements of an array or the value alone
+func parseConfigValue(text string) ([]string, error) {
+	if !strings.HasPrefix(text, "[") {
+		value, rest, err := parseConfigScalar(text)
+		if err != nil {
+			return nil, err
+		}
+		if !isConfigComment(rest) {
+			return nil, fmt.Errorf("unexpected %q after the value", rest)
+		}
+		return []string{value}, nil
+	}
+
+	var values []string
+	rest := strings.TrimSpace(text[1:])
+	for !strings.HasPrefix(rest, "]") {
+		value, after, err := parseConfigScalar(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		rest = strings.TrimSpace(after)
+		if strings.HasPrefix(rest, ",") {
+			rest = strings.TrimSpace(rest[1:])
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+	if !isConfigComment(rest[1:]) {
+		return nil, fmt.Errorf("unexpected %q after the array", rest[1:])
+	}
+	return values, nil
+}
+
+// parseConfigScalar parses the string, number or boolean text starts with
+// and returns it along with the text after it. Double quoted strings take
+// Go escapes, single quoted strings are taken literally.
+func parseConfigScalar(text string) (value string, rest string, err error) {
+	switch {
+	case strings.HasPrefix(text, `"`):
+		quoted, err := strconv.QuotedPrefix(text)
+		if err != nil {
+			return "", "", fmt.Errorf("unterminated or invalid string")
+		}
+		value, err := strconv.Unquote(quoted)
+		return value, text[len(quoted):], err
+	case strings.HasPrefix(text, "'"):
+		end := strings.IndexByte(text[1:], '\'')
+		if end == -1 {
+			return "", "", fmt.Errorf("unterminated string")
+		}
+		return text[1 : end+1], text[end+2:], nil
+	}
+
+	// Bare values end at whitespace, a comma, a bracket or a comment
+	end := strings.IndexAny(text, " \t,]#")
+	if end == -1 {
+		end = len(text)
+	}
+	value = text[:end]
+	if value == "" {
+		return "", "", fmt.Errorf("missing value")
+	}
+	if value != "true" && value != "false" {
+		if _, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64); err != nil {
+			return "", "", fmt.Errorf("%q must be quoted", value)
+		}
+		value = strings.ReplaceAll(value, "_", "")
+	}
+	return value, text[end:], nil
+}
+
+// isConfigComment reports whether the rest of a line holds nothing but an
+// optional comment
+func isConfigComment(rest string) bool {
+	rest = strings.TrimSpace(rest)
+	return rest == "" || strings.HasPrefix(rest, "#")
+}
@@ -0,0 +1,2028 @@
+// Package convert turns American Express CSV exports into YNAB's import format
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ColumnMapper helps map source columns to target columns. Each field lists
+// its column names by precedence: when several are in the header, the
+// earliest name wins.
+type ColumnMapper struct {
+	DateColumns      []string `json:"dateColumns"`
+	PayeeColumns     []string `json:"payeeColumns"`
+	AmountColumns    []string `json:"amountColumns"`
+	MemoColumns      []string `json:"memoColumns"`
+	ReferenceColumns []string `json:"referenceColumns"`
+	LocationColumns  []string `json:"locationColumns"`
+	PostcodeColumns  []string `json:"postcodeColumns"`
+	CountryColumns   []string `json:"countryColumns"`
+	StatusColumns    []string `json:"statusColumns"`
+	CategoryColumns  []string `json:"categoryColumns"`
+	// ForeignAmountColumns and ForeignCurrencyColumns hold the original
+	// purchase amount of foreign transactions
+	ForeignAmountColumns   []string `json:"foreignAmountColumns"`
+	ForeignCurrencyColumns []string `json:"foreignCurrencyColumns"`
+	// IndicatorColumns hold a debit/credit marker that gives the sign of an
+	// always positive amount
+	IndicatorColumns []string `json:"indicatorColumns"`
+	// DebitColumns and CreditColumns hold unsigned amounts in separate
+	// columns, used when there's no AmountColumns match
+	DebitColumns  []string `json:"debitColumns"`
+	CreditColumns []string `json:"creditColumns"`
+	// AccountColumns name the card or account of each row, for exports
+	// that combine several. It fills an "Account" column of the output
+	// header.
+	AccountColumns []string `json:"accountColumns"`
+	// CardMemberColumns name who made the purchase on supplementary cards,
+	// added to the memo as "By: Name"
+	CardMemberColumns []string `json:"cardMemberColumns"`
+}
+
+// Output formats supported by the -format flag
+const (
+	FormatAmount        = "amount"
+	FormatInflowOutflow = "inflow-outflow"
+)
+
+// Options controls how ProcessCSV shapes the YNAB output
+type Options struct {
+	// Logger receives warnings and per-row traces, nil only logs warnings
+	// with the standard log package
+	Logger Logger
+	// Progress is called with the number of rows read so far, across all
+	// inputs, every 1000 rows. Nil reports nothing.
+	Progress func(rows int)
+	// OnProblem is called for every row that doesn't convert cleanly, as
+	// -validate reports them. Nil reports nothing.
+	OnProblem func(p Problem)
+	// Format is the CSV amount layout, FormatAmount or FormatInflowOutflow
+	Format string
+	// OutputFormat is the output file format, OutputCSV (default), OutputQIF
+	// or OutputJSON. JSON can't pass an unparsed date or amount through, so
+	// such rows are left out and counted in Summary.Rejected instead.
+	OutputFormat string
+	// DateLayout is the Go time layout CSV dates are written in, empty means
+	// the ISO DateLayout. See DateFormats for common ones.
+	DateLayout string
+	// AmountFormat is how CSV amounts are written, AmountDecimal (default)
+	// or AmountMilliunits
+	AmountFormat string
+	// CRLF ends CSV lines with \r\n instead of \n
+	CRLF bool
+	// OutputBOM starts CSV output with a UTF-8 byte order mark, so Excel
+	// shows accented characters correctly. It's written with the header.
+	OutputBOM bool
+	// SkipHeader leaves the header out, for appending to an output that
+	// already starts with one
+	SkipHeader bool
+	// Header overrides the CSV header names and their order, see ValidateHeader
+	Header []string
+	// Mapper overrides the built-in column names when set
+	Mapper *ColumnMapper
+	// Delimiter forces the input field separator, zero means auto-detect
+	Delimiter rune
+	// DefaultDelimiter is the input field separator when Delimiter is zero
+	// and the header line has no comma, semicolon or tab. Zero means comma.
+	DefaultDelimiter rune
+	// InputDateLayouts are Go time layouts tried on input dates before the
+	// built-in ones, to settle ambiguous dates such as 03/04/2024
+	InputDateLayouts []string
+	// SkipRows discards this many lines of preamble before the header
+	SkipRows int
+	// AutoHeader looks for the header among the first lines after SkipRows,
+	// picking the one naming the most known columns, and discards the lines
+	// before it
+	AutoHeader bool
+	// From and To limit the output to an inclusive date range, zero means unbounded
+	From time.Time
+	To   time.Time
+	// Strict aborts the conversion on a date or amount that can't be parsed,
+	// instead of passing the original value through. Together with Rejects
+	// such rows are left out of the output instead.
+	Strict bool
+	// Rejects receives every row whose date or amount couldn't be parsed, as
+	// CSV with the original input columns and header. Nil records nothing.
+	Rejects io.Writer
+	// Currency is the expected ISO code of the amounts, empty accepts any.
+	// Rows with an amount marked with another currency are left out, sent
+	// to Rejects and counted in Summary.Rejected. Strict without Rejects
+	// aborts with a *CurrencyError instead.
+	Currency string
+	// Dedup skips rows whose DedupKey fields match a row converted earlier in
+	// the same run
+	Dedup bool
+	// DedupKey lists the DedupFields that identify a transaction, defaulting
+	// to date, payee, amount and memo
+	DedupKey []string
+	// PayeeJoin joins every column matching the payee aliases with this
+	// separator, in alias order, instead of only using the first. Empty uses
+	// a single column.
+	PayeeJoin string
+	// PayeeFromMemo takes the payee from the memo column and puts the payee
+	// column first in the memo instead, for exports whose payee column holds
+	// codes. Rows with an empty memo column keep their payee.
+	PayeeFromMemo bool
+	// MaxPayeeLength truncates longer payees to this many characters, as
+	// YNAB cuts payees off at 200. Zero keeps payees whole.
+	MaxPayeeLength int
+	// PayeeOverflowToMemo puts the part of the payee that MaxPayeeLength
+	// cut off at the start of the memo
+	PayeeOverflowToMemo bool
+	// PayeeRules rewrite the payee, applied in order
+	PayeeRules []PayeeRule
+	// SignRules force the sign of matching transactions after every other
+	// sign convention, the first matching rule wins
+	SignRules []SignRule
+	// SkipPending drops rows whose status column says "pending"
+	SkipPending bool
+	// DropPayments skips rows whose payee matches it, such as payments made
+	// to the card itself. Nil keeps every row.
+	DropPayments *regexp.Regexp
+	// Include keeps only rows whose cleaned up payee matches it and Exclude
+	// drops rows whose payee matches it, winning over Include. Nil keeps
+	// every row.
+	Include *regexp.Regexp
+	Exclude *regexp.Regexp
+	// DropZero skips rows whose amount parses to zero, such as
+	// authorization holds and reversals
+	DropZero bool
+	// KeepEmptyAmount keeps rows without any amount, such as informational
+	// entries, with the amount left blank. By default they are dropped as
+	// YNAB rejects blank amounts. It can't be combined with OutputJSON.
+	KeepEmptyAmount bool
+	// ColumnOverrides picks the column of a field by index, keyed by the
+	// lowercase field name reported in Summary.Columns, e.g. "amount"
+	ColumnOverrides map[string]int
+	// ResolveColumns is called with the header and the missing fields when
+	// required columns aren't found, and returns column indices keyed like
+	// ColumnOverrides, e.g. by asking the user. Nil fails the conversion.
+	ResolveColumns func(header []string, missing []string) (map[string]int, error)
+	// FuzzyColumns matches columns whose name contains an alias when no
+	// column matches it exactly
+	FuzzyColumns bool
+	// KeepRawAmount adds the amount as it appeared in the input to the memo
+	KeepRawAmount bool
+	// OmitMemo leaves the memo of every transaction empty, keeping only the
+	// date, payee and amount
+	OmitMemo bool
+	// MemoRefDate writes the reference as "ID: 20240315-REF123", prefixed
+	// with the transaction date, instead of "Ref: REF123". Rows without a
+	// valid date keep the plain reference.
+	MemoRefDate bool
+	// LocationLabeled writes the city, postcode and country as separate
+	// memo parts, as in "City: Amsterdam | Country: NL", instead of one
+	// "Location: Amsterdam, NL"
+	LocationLabeled bool
+	// ExpandCountries writes two-letter country codes in the location as
+	// the country's name, e.g. NL as Netherlands. Unknown codes are kept.
+	ExpandCountries bool
+	// MemoFields picks the memo parts and their order from
+	// DefaultMemoFields, see ValidateMemoFields. Empty writes all of them.
+	MemoFields []string
+	// ExtraColumns names more input columns added to the memo as
+	// "Name: value" with the name as the header spells it, after the
+	// built-in memo fields. Columns missing from the header are skipped.
+	ExtraColumns []string
+	// Tag is added to the end of every memo, such as "Card: Gold" to tell
+	// imported cards apart. Empty adds nothing.
+	Tag string
+	// MemoSeparator joins the parts of the memo, empty means
+	// DefaultMemoSeparator. See ValidateMemoSeparator.
+	MemoSeparator string
+	// Locale picks the decimal separator of input amounts, LocaleEN for a dot
+	// and LocaleNL for a comma. LocaleAuto detects it per input. Empty
+	// guesses it per amount.
+	Locale string
+	// Rounding is how amounts with more than two decimals are rounded to
+	// cents, RoundHalfEven (default), RoundHalfUp or RoundTruncate
+	Rounding string
+	// KeepSign passes amounts through without inverting them, for exports
+	// that already sign charges negative. With FormatInflowOutflow the sign
+	// after this step picks the column, so negative amounts become outflows.
+	KeepSign bool
+	// Limit stops the conversion after this many rows are written across all
+	// inputs, zero or negative means no limit
+	Limit int
+	// Sort orders the written transactions by date, SortAsc or SortDesc.
+	// Every transaction is then held in memory until Close, instead of
+	// being streamed. Limit still counts rows in input order. Empty or
+	// SortNone keeps input order.
+	Sort string
+	// KeepWhitespace passes the payee and memo fields through as they are,
+	// instead of trimming them and collapsing runs of whitespace
+	KeepWhitespace bool
+	// DebitIndicators are the values of an indicator column, compared
+	// ignoring case, that mark a debit. Nil means DefaultDebitIndicators.
+	DebitIndicators []string
+}
+
+// Locales supported by Options.Locale
+const (
+	LocaleEN = "en"
+	LocaleNL = "nl"
+	// LocaleAuto picks LocaleEN or LocaleNL per input from a sample of its
+	// amounts, guessing per amount if the sample doesn't tell
+	LocaleAuto = "auto"
+)
+
+// DefaultPaymentPattern matches the descriptions Amex uses for payments made
+// to the card
+const DefaultPaymentPattern = `(?i)payment received|thank you|bedankt voor uw betaling|betaling ontvangen`
+
+// DefaultDebitIndicators are the indicator column values that mark a debit
+// when Options.DebitIndicators is nil
+var DefaultDebitIndicators = []string{"D", "Debit", "Af"}
+
+// DefaultMemoSeparator joins the memo parts when Options.MemoSeparator is empty
+const DefaultMemoSeparator = " | "
+
+// ValidateMemoSeparator checks that a memo separator is non-empty and free of
+// double quotes and carriage returns, which CSV readers escape or normalize
+// differently. Newlines are allowed; the CSV writer quotes them.
+func ValidateMemoSeparator(sep string) error {
+	if sep == "" {
+		return fmt.Errorf("memo separator must not be empty")
+	}
+	if strings.ContainsAny(sep, "\"\r") {
+		return fmt.Errorf("memo separator %q must not contain double quotes or carriage returns", sep)
+	}
+	return nil
+}
+
+// Memo parts Options.MemoFields can pick
+const (
+	// MemoInfo is the memo column, e.g. Aanvullende informatie
+	MemoInfo = "info"
+	// MemoRef is the reference as "Ref: REF123"
+	MemoRef = "ref"
+	// MemoLocation is the city, postcode and country
+	MemoLocation = "location"
+	// MemoCategory is the category as "Category: Travel"
+	MemoCategory = "category"
+	// MemoFX is the foreign purchase amount as "FX: 12.34 USD"
+	MemoFX = "fx"
+	// MemoMember is the card member as "By: J DOE"
+	MemoMember = "member"
+)
+
+// DefaultMemoFields are the memo parts written when Options.MemoFields is
+// empty, in order
+var DefaultMemoFields = []string{MemoInfo, MemoRef, MemoLocation, MemoCategory, MemoFX, MemoMember}
+
+// ValidateMemoFields checks that every field is one of DefaultMemoFields
+// and appears only once
+func ValidateMemoFields(fields []string) error {
+	for i, field := range fields {
+		if !slices.Contains(DefaultMemoFields, field) {
+			return fmt.Errorf("unknown memo field %q, expected %s", field, strings.Join(DefaultMemoFields, ", "))
+		}
+		if slices.Contains(fields[:i], field) {
+			return fmt.Errorf("memo field %q appears more than once", field)
+		}
+	}
+	return nil
+}
+
+// DedupFields are the row fields a deduplication key can be built from
+var DedupFields = []string{"date", "payee", "amount", "memo", "reference"}
+
+// defaultDedupKey is used when Options.DedupKey is empty
+var defaultDedupKey = []string{"date", "payee", "amount", "memo"}
+
+// ynabAmount parses an Amex amount and applies YNAB's sign convention by
+// inverting it, unless KeepSign is set. A non-empty debit/credit indicator
+// gives the sign instead: debits become outflows and anything else inflows.
+func (o Options) ynabAmount(amountStr string, indicator string) (float64, error) {
+	amount, _, err := parseMoney(amountStr, o.Locale)
+	if err != nil {
+		return 0, err
+	}
+	amount = roundCents(amount, o.Rounding)
+	if indicator = strings.TrimSpace(indicator); indicator != "" {
+		if o.isDebit(indicator) {
+			return -math.Abs(amount), nil
+		}
+		return math.Abs(amount), nil
+	}
+	if o.KeepSign {
+		return amount, nil
+	}
+	return -amount, nil
+}
+
+// netAmount parses the unsigned amounts of separate debit and credit
+// columns, either of which may be empty, into a YNAB amount of credit minus
+// debit
+func (o Options) netAmount(debitStr string, creditStr string) (float64, error) {
+	if strings.TrimSpace(debitStr) == "" && strings.TrimSpace(creditStr) == "" {
+		return 0, fmt.Errorf("no debit or credit amount")
+	}
+
+	var net float64
+	for _, column := range []struct {
+		amount string
+		sign   float64
+	}{{debitStr, -1}, {creditStr, 1}} {
+		if strings.TrimSpace(column.amount) == "" {
+			continue
+		}
+		amount, _, err := parseMoney(column.amount, o.Locale)
+		if err != nil {
+			return 0, err
+		}
+		net += column.sign * math.Abs(roundCents(amount, o.Rounding))
+	}
+	return net, nil
+}
+
+// isDebit reports whether an indicator column value marks a debit
+func (o Options) isDebit(indicator string) bool {
+	debits := o.DebitIndicators
+	if debits == nil {
+		debits = DefaultDebitIndicators
+	}
+	return slices.ContainsFunc(debits, func(debit string) bool {
+		return strings.EqualFold(debit, indicator)
+	})
+}
+
+// Logger receives diagnostics from a conversion
+type Logger interface {
+	Warnf(format string, args ...any)
+	Debugf(format string, args ...any)
+}
+
+// stdLogger logs warnings with the standard log package and drops traces
+type stdLogger struct{}
+
+func (stdLogger) Warnf(format string, args ...any) {
+	log.Printf(format, args...)
+}
+
+func (stdLogger) Debugf(format string, args ...any) {}
+
+// memoSeparator returns the configured memo separator or the default one
+func (o Options) memoSeparator() string {
+	if o.MemoSeparator == "" {
+		return DefaultMemoSeparator
+	}
+	return o.MemoSeparator
+}
+
+// logger returns the configured Logger or the standard one
+func (o Options) logger() Logger {
+	if o.Logger == nil {
+		return stdLogger{}
+	}
+	return o.Logger
+}
+
+// DateLayout is the YYYY-MM-DD layout YNAB dates are written in
+const DateLayout = "2006-01-02"
+
+// DateFormats maps friendly names to the date layouts YNAB's importer
+// understands
+var DateFormats = map[string]string{
+	"iso": DateLayout,
+	"us":  "01/02/2006",
+	"eu":  "02/01/2006",
+}
+
+// keepDate reports whether a normalized date falls within the From/To range.
+// Dates that can't be parsed are kept.
+func (o Options) keepDate(date string) bool {
+	if o.From.IsZero() && o.To.IsZero() {
+		return true
+	}
+
+	t, err := time.Parse(DateLayout, date)
+	if err != nil {
+		return true
+	}
+	if !o.From.IsZero() && t.Before(o.From) {
+		return false
+	}
+	if !o.To.IsZero() && t.After(o.To) {
+		return false
+	}
+	return true
+}
+
+// ColumnMatch records which input column a mapped field was found in
+type ColumnMatch struct {
+	Field string
+	// Index is the position in the input header, -1 when not found
+	Index int
+}
+
+// Summary describes the outcome of a conversion
+type Summary struct {
+	Header       []string
+	Columns      []ColumnMatch
+	Transactions int
+	Inflow       float64
+	Outflow      float64
+	// FirstDate and LastDate are the earliest and latest YYYY-MM-DD dates
+	// written, empty when no written date parsed
+	FirstDate string
+	LastDate  string
+	// Skipped counts rows whose amount couldn't be parsed and is missing from the totals
+	Skipped int
+	// Duplicates counts rows dropped by Options.Dedup
+	Duplicates int
+	// Pending counts rows dropped by Options.SkipPending
+	Pending int
+	// Malformed counts rows skipped for having too few fields
+	Malformed int
+	// Payments counts rows dropped by Options.DropPayments
+	Payments int
+	// Zero counts rows dropped by Options.DropZero
+	Zero int
+	// Filtered counts rows dropped by Options.Include and Options.Exclude
+	Filtered int
+	// EmptyAmounts counts rows without an amount, dropped unless
+	// Options.KeepEmptyAmount is set
+	EmptyAmounts int
+	// DateErrors counts rows whose date couldn't be parsed and was passed through
+	DateErrors int
+	// Rejected counts rows left out of the output for a date or amount that
+	// couldn't be parsed, with Options.Strict and Options.Rejects both set
+	// or with OutputJSON, and rows in another currency than Options.Currency
+	Rejected int
+}
+
+// Kinds of Problem
+const (
+	// ProblemFieldCount is a row with another number of fields than the
+	// header. Rows too short for the required columns are skipped.
+	ProblemFieldCount = "field count"
+	ProblemDate       = "date"
+	ProblemAmount     = "amount"
+)
+
+// Problem is a row that doesn't convert cleanly
+type Problem struct {
+	// Kind is one of ProblemFieldCount, ProblemDate or ProblemAmount
+	Kind string
+	Line int
+	// Value is the offending value, or the number of fields for
+	// ProblemFieldCount
+	Value string
+}
+
+// HadParseErrors reports whether any date or amount was passed through
+// unparsed or rejected
+func (s Summary) HadParseErrors() bool {
+	return s.DateErrors > 0 || s.Skipped > 0 || s.Rejected > 0
+}
+
+// Merge adds the counts and totals of other to s and takes its header and
+// columns, for combining the conversions of separate inputs
+func (s *Summary) Merge(other Summary) {
+	s.Header = other.Header
+	s.Columns = other.Columns
+	s.Transactions += other.Transactions
+	s.Inflow += other.Inflow
+	s.Outflow += other.Outflow
+	if other.FirstDate != "" {
+		s.addDate(other.FirstDate)
+		s.addDate(other.LastDate)
+	}
+	s.Skipped += other.Skipped
+	s.Duplicates += other.Duplicates
+	s.Pending += other.Pending
+	s.Malformed += other.Malformed
+	s.Payments += other.Payments
+	s.Zero += other.Zero
+	s.Filtered += other.Filtered
+	s.EmptyAmounts += other.EmptyAmounts
+	s.DateErrors += other.DateErrors
+	s.Rejected += other.Rejected
+}
+
+// Net returns the inflow minus the outflow
+func (s Summary) Net() float64 {
+	return s.Inflow - s.Outflow
+}
+
+// addDate widens FirstDate and LastDate to include a YYYY-MM-DD date
+func (s *Summary) addDate(date string) {
+	if s.FirstDate == "" || date < s.FirstDate {
+		s.FirstDate = date
+	}
+	if s.LastDate == "" || date > s.LastDate {
+		s.LastDate = date
+	}
+}
+
+// addAmount adds a YNAB signed amount to the inflow or outflow total
+func (s *Summary) addAmount(amount float64) {
+	if amount < 0 {
+		s.Outflow += -amount
+	} else {
+		s.Inflow += amount
+	}
+}
+
+// errEmptyAmount marks rows kept by Options.KeepEmptyAmount, which are
+// written with the amount blank
+var errEmptyAmount = errors.New("no amount")
+
+// flushInterval is the number of rows written between output flushes
+const flushInterval = 1000
+
+// progressInterval is the number of rows read between Options.Progress calls
+const progressInterval = 1000
+
+// ProcessCSV converts an Amex CSV export read from inputFile into YNAB's CSV
+// import format written to outputFile
+func ProcessCSV(inputFile io.Reader, outputFile io.Writer, opts Options) (Summary, error) {
+	converter := NewConverter(outputFile, opts)
+	err := converter.Convert(inputFile)
+	if closeErr := converter.Close(); err == nil {
+		err = closeErr
+	}
+	return converter.Summary(), err
+}
+
+// ReadColumns reads only the header of an Amex CSV export and returns it in
+// Summary.Header along with the matched columns, without requiring any
+func ReadColumns(inputFile io.Reader, opts Options) (Summary, error) {
+	converter := &Converter{opts: opts, out: newTransactionWriter(io.Discard, opts), headerOnly: true}
+	err := converter.Convert(inputFile)
+	return converter.Summary(), err
+}
+
+// Converter converts one or more Amex CSV exports into a single YNAB import,
+// writing the YNAB header only once
+type Converter struct {
+	opts          Options
+	out           transactionWriter
+	headerWritten bool
+	summary       Summary
+	// rejects writes the rows for Options.Rejects, rejectsHeader is the
+	// input header written to it last
+	rejects       *csv.Writer
+	rejectsHeader []string
+	// rows counts the data rows read from every input so far
+	rows int
+	// headerOnly stops each conversion after the columns are matched
+	headerOnly bool
+	// seen holds the dedup key hashes of the rows written so far
+	seen map[[sha256.Size]byte]bool
+}
+
+// NewConverter returns a Converter writing to outputFile
+func NewConverter(outputFile io.Writer, opts Options) *Converter {
+	out := newSortWriter(newTransactionWriter(outputFile, opts), opts)
+	return &Converter{opts: opts, out: out, seen: map[[sha256.Size]byte]bool{}}
+}
+
+// NewMonthlyConverter returns a Converter that writes each calendar month
+// to its own output, calling open with the month as YYYY-MM, or UnknownMonth
+// for dates that couldn't be parsed, the first time a row for it is written.
+// Every output gets its own header.
+func NewMonthlyConverter(open func(month string) (io.Writer, error), opts Options) *Converter {
+	out := newSortWriter(&monthWriter{open: open, opts: opts, writers: map[string]transactionWriter{}}, opts)
+	return &Converter{opts: opts, out: out, seen: map[[sha256.Size]byte]bool{}}
+}
+
+// NewSampleConverter returns a Converter that writes only the first n
+// transactions to outputFile, as a preview, while still converting and
+// counting every row. The output has no byte order mark.
+func NewSampleConverter(outputFile io.Writer, n int, opts Options) *Converter {
+	opts.OutputBOM = false
+	out := newSortWriter(&headWriter{out: newTransactionWriter(outputFile, opts), n: n}, opts)
+	return &Converter{opts: opts, out: out, seen: map[[sha256.Size]byte]bool{}}
+}
+
+// isDuplicate reports whether a row with the same dedup key fields was seen
+// before, and remembers the row otherwise
+func (c *Converter) isDuplicate(fields map[string]string) bool {
+	key := c.opts.DedupKey
+	if len(key) == 0 {
+		key = defaultDedupKey
+	}
+
+	hash := sha256.New()
+	for _, field := range key {
+		// Separate the values so adjacent fields can't run together
+		hash.Write([]byte(fields[field]))
+		hash.Write([]byte{0})
+	}
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+
+	if c.seen[sum] {
+		return true
+	}
+	c.seen[sum] = true
+	return false
+}
+
+// Summary returns the totals of every input converted so far, along with the
+// columns matched in the most recent one
+func (c *Converter) Summary() Summary {
+	return c.summary
+}
+
+// Flush writes any buffered rows to the output
+func (c *Converter) Flush() error {
+	if err := c.flushRejects(); err != nil {
+		return err
+	}
+	return c.out.flush()
+}
+
+// Close finishes the output after the last input, such as closing the JSON
+// array, and flushes it. The converter can't be used afterwards.
+func (c *Converter) Close() error {
+	if err := c.flushRejects(); err != nil {
+		return err
+	}
+	return c.out.close()
+}
+
+// reject writes a row that couldn't be converted cleanly to Options.Rejects,
+// preceded by its input header when that differs from the last one written
+func (c *Converter) reject(header []string, row []string) error {
+	if c.opts.Rejects == nil {
+		return nil
+	}
+	if c.rejects == nil {
+		c.rejects = csv.NewWriter(c.opts.Rejects)
+	}
+	if !slices.Equal(header, c.rejectsHeader) {
+		if err := c.rejects.Write(header); err != nil {
+			return fmt.Errorf("failed to write rejected row: %w", err)
+		}
+		c.rejectsHeader = header
+	}
+	if err := c.rejects.Write(row); err != nil {
+		return fmt.Errorf("failed to write rejected row: %w", err)
+	}
+	return nil
+}
+
+// problem reports a row that doesn't convert cleanly to Options.OnProblem
+func (c *Converter) problem(kind string, line int, value string) {
+	if c.opts.OnProblem != nil {
+		c.opts.OnProblem(Problem{Kind: kind, Line: line, Value: value})
+	}
+}
+
+// flushRejects writes any buffered rejected rows
+func (c *Converter) flushRejects() error {
+	if c.rejects == nil {
+		return nil
+	}
+	c.rejects.Flush()
+	return c.rejects.Error()
+}
+
+// Convert appends the rows of an Amex CSV export read from inputFile to the output
+func (c *Converter) Convert(inputFile io.Reader) error {
+	return c.ConvertDelimited(inputFile, c.opts.Delimiter)
+}
+
+// ConvertDelimited is Convert with the field separator of this input forced
+// to delimiter, zero means auto-detect
+func (c *Converter) ConvertDelimited(inputFile io.Reader, delimiter rune) error {
+	opts := c.opts
+	opts.Delimiter = delimiter
+	logger := opts.logger()
+	memoSep := opts.memoSeparator()
+	summary := &c.summary
+
+	// Create CSV reader, reusing the row slice between reads to keep memory
+	// flat. Only the strings in a row are kept, never the slice itself.
+	bufferedInput := bufio.NewReaderSize(inputFile, localeSampleBytes)
+	reader := csv.NewReader(bufferedInput)
+	reader.ReuseRecord = true
+	// Allow rows with a different number of fields, short rows are skipped below
+	reader.FieldsPerRecord = -1
+
+	// Drop a UTF-8 BOM so it doesn't end up in the first header name
+	skipBOM(bufferedInput)
+
+	// Discard preamble lines so the header is read from the right line
+	if err := skipLines(bufferedInput, opts.SkipRows); err != nil {
+		return fmt.Errorf("failed to skip %d preamble lines: %w", opts.SkipRows, err)
+	}
+
+	// Create a column mapper
+	mapper := CreateColumnMapper()
+	if opts.Mapper != nil {
+		mapper = *opts.Mapper
+	}
+
+	// Find index of each mapped column
+	var dateIdx, payeeIdx, amountIdx, memoIdx, referenceIdx, locationIdx, postcodeIdx, countryIdx int
+	var statusIdx, categoryIdx, foreignAmountIdx, foreignCurrencyIdx, indicatorIdx, debitIdx, creditIdx, accountIdx, cardMemberIdx int
+	lookups := []columnLookup{
+		{Field: "Date", Aliases: mapper.DateColumns, Index: &dateIdx},
+		{Field: "Payee", Aliases: mapper.PayeeColumns, Index: &payeeIdx},
+		{Field: "Amount", Aliases: mapper.AmountColumns, Index: &amountIdx},
+		{Field: "Memo", Aliases: mapper.MemoColumns, Index: &memoIdx},
+		{Field: "Reference", Aliases: mapper.ReferenceColumns, Index: &referenceIdx},
+		{Field: "Location", Aliases: mapper.LocationColumns, Index: &locationIdx},
+		{Field: "Postcode", Aliases: mapper.PostcodeColumns, Index: &postcodeIdx},
+		{Field: "Country", Aliases: mapper.CountryColumns, Index: &countryIdx},
+		{Field: "Status", Aliases: mapper.StatusColumns, Index: &statusIdx},
+		{Field: "Category", Aliases: mapper.CategoryColumns, Index: &categoryIdx},
+		{Field: "Foreign amount", Aliases: mapper.ForeignAmountColumns, Index: &foreignAmountIdx},
+		{Field: "Foreign currency", Aliases: mapper.ForeignCurrencyColumns, Index: &foreignCurrencyIdx},
+		{Field: "Debit/credit indicator", Aliases: mapper.IndicatorColumns, Index: &indicatorIdx},
+		{Field: "Debit", Aliases: mapper.DebitColumns, Index: &debitIdx},
+		{Field: "Credit", Aliases: mapper.CreditColumns, Index: &creditIdx},
+		{Field: "Account", Aliases: mapper.AccountColumns, Index: &accountIdx},
+		{Field: "Card member", Aliases: mapper.CardMemberColumns, Index: &cardMemberIdx},
+	}
+	// Discard a preamble of unknown length by looking for the header
+	if opts.AutoHeader {
+		line := findHeaderLine(bufferedInput, lookups)
+		logger.Debugf("Header found on line %d", opts.SkipRows+line+1)
+		if err := skipLines(bufferedInput, line); err != nil {
+			return fmt.Errorf("failed to skip %d preamble lines: %w", line, err)
+		}
+	}
+
+	// Use the forced delimiter or detect it from the header line
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	} else {
+		fallback := opts.DefaultDelimiter
+		if fallback == 0 {
+			fallback = ','
+		}
+		reader.Comma = detectDelimiter(bufferedInput, fallback)
+	}
+
+	// Read the header
+	header, err := reader.Read()
+	if err == io.EOF {
+		return ErrEmptyInput
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read header: %w", err)
+	}
+	// The next read overwrites the reused slice, so keep a copy
+	header = slices.Clone(header)
+
+	columns := newHeaderIndex(header)
+	findColumns(header, columns, lookups, opts.FuzzyColumns)
+	if err := overrideColumns(header, lookups, opts.ColumnOverrides); err != nil {
+		return err
+	}
+
+	// Warn about matches that are ambiguous because the name repeats
+	for _, lookup := range lookups {
+		if _, overridden := opts.ColumnOverrides[strings.ToLower(lookup.Field)]; overridden || *lookup.Index == -1 {
+			continue
+		}
+		name := header[*lookup.Index]
+		if count := columns.count(name); count > 1 {
+			logger.Warnf("%s: column name %q appears %d times, using column %d; override the column to pick another", lookup.Field, name, count, *lookup.Index)
+		}
+	}
+
+	// Record which columns were matched
+	summary.Header = header
+	summary.Columns = make([]ColumnMatch, len(lookups))
+	for i, lookup := range lookups {
+		summary.Columns[i] = ColumnMatch{Field: lookup.Field, Index: *lookup.Index}
+	}
+
+	for _, match := range summary.Columns {
+		if match.Index == -1 {
+			logger.Debugf("%s: no matching column", match.Field)
+		} else {
+			logger.Debugf("%s: matched column %d (%s)", match.Field, match.Index, header[match.Index])
+		}
+	}
+
+	if c.headerOnly {
+		return nil
+	}
+
+	// Find the extra memo columns, skipping those the header lacks
+	var extraColumns []extraColumn
+	for _, name := range opts.ExtraColumns {
+		idx := columns.find([]string{name})
+		if idx == -1 {
+			logger.Debugf("Extra column %q: no matching column", name)
+			continue
+		}
+		extraColumns = append(extraColumns, extraColumn{name: strings.TrimSpace(header[idx]), idx: idx})
+	}
+
+	// Find every payee column when they're joined
+	var payeeIndices []int
+	if opts.PayeeJoin != "" {
+		payeeIndices = columns.findAll(mapper.PayeeColumns)
+	}
+
+	// Without an amount column, take the amount from debit and credit columns
+	splitAmounts := amountIdx == -1 && (debitIdx != -1 || creditIdx != -1)
+
+	// Check if required columns were found, letting ResolveColumns pick
+	// the missing ones
+	required := func() []requiredColumn {
+		required := []requiredColumn{
+			{Field: "Date", Index: dateIdx, Aliases: mapper.DateColumns},
+			{Field: "Payee", Index: payeeIdx, Aliases: mapper.PayeeColumns},
+		}
+		if !splitAmounts {
+			required = append(required, requiredColumn{Field: "Amount", Index: amountIdx, Aliases: mapper.AmountColumns})
+		}
+		return required
+	}
+	if err := checkRequiredColumns(header, required()); err != nil {
+		var missing *MissingColumnsError
+		if opts.ResolveColumns == nil || !errors.As(err, &missing) {
+			return err
+		}
+		picks, err := opts.ResolveColumns(header, missing.Fields)
+		if err != nil {
+			return err
+		}
+		if err := overrideColumns(header, lookups, picks); err != nil {
+			return err
+		}
+		for i, lookup := range lookups {
+			summary.Columns[i].Index = *lookup.Index
+		}
+		if err := checkRequiredColumns(header, required()); err != nil {
+			return err
+		}
+	}
+
+	// Pick the decimal separator of this input from a sample of its amounts
+	if opts.Locale == LocaleAuto {
+		amountColumns := []int{amountIdx}
+		if splitAmounts {
+			amountColumns = []int{debitIdx, creditIdx}
+		}
+		opts.Locale = detectLocale(sampleColumns(bufferedInput, reader.Comma, amountColumns))
+		if opts.Locale == "" {
+			logger.Debugf("Decimal separator: unclear from the amounts, guessing per amount")
+		} else {
+			logger.Debugf("Decimal separator: detected locale %s", opts.Locale)
+		}
+	}
+
+	// Write YNAB header once, before the rows of the first input
+	if !c.headerWritten {
+		if !opts.SkipHeader {
+			if err := c.out.writeHeader(); err != nil {
+				return fmt.Errorf("failed to write header: %w", err)
+			}
+		}
+		c.headerWritten = true
+	}
+
+	// Write the default memo parts unless others were picked
+	memoFields := opts.MemoFields
+	if len(memoFields) == 0 {
+		memoFields = DefaultMemoFields
+	}
+
+	// Tidy the whitespace of memo fields unless raw values are wanted
+	text := field
+	if !opts.KeepWhitespace {
+		text = func(row []string, idx int) string {
+			return collapseWhitespace(field(row, idx))
+		}
+	}
+
+	// Rows must reach the required columns, and with debit and credit
+	// columns at least the first of them
+	lastRequired := max(dateIdx, payeeIdx, amountIdx)
+	if splitAmounts {
+		firstAmount := debitIdx
+		if firstAmount == -1 || (creditIdx != -1 && creditIdx < firstAmount) {
+			firstAmount = creditIdx
+		}
+		lastRequired = max(lastRequired, firstAmount)
+	}
+
+	// Process each row, stopping once the limit is written. Lines count
+	// from the header as line 1.
+	rowLine := 1
+	for opts.Limit <= 0 || summary.Transactions < opts.Limit {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			// The CSV error names its line already
+			return fmt.Errorf("failed to read row: %w", err)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read row after line %d: %w", rowLine, err)
+		}
+		rowLine, _ = reader.FieldPos(0)
+
+		// Report progress on long conversions
+		c.rows++
+		if opts.Progress != nil && c.rows%progressInterval == 0 {
+			opts.Progress(c.rows)
+		}
+
+		// Report rows whose length differs from the header
+		if len(row) != len(header) {
+			c.problem(ProblemFieldCount, rowLine, strconv.Itoa(len(row)))
+		}
+
+		// Skip rows too short to hold the required columns
+		if len(row) <= lastRequired {
+			logger.Warnf("Skipping line %d: expected at least %d fields, got %d", rowLine, lastRequired+1, len(row))
+			summary.Malformed++
+			continue
+		}
+
+		// Keep quoted multi-line cells on a single line
+		for i, value := range row {
+			row[i] = singleLine(value)
+		}
+
+		// Extract and format date, keeping the original when it can't be
+		// parsed. Rows that pass the filters below report it.
+		date := row[dateIdx]
+		parsedDate, dateErr := parseDateLayouts(row[dateIdx], opts.InputDateLayouts)
+		if dateErr == nil {
+			date = parsedDate.Format(DateLayout)
+		}
+
+		// Skip rows outside the requested date range
+		if !opts.keepDate(date) {
+			logger.Debugf("Skipping line %d: date %s is outside the date range", rowLine, date)
+			continue
+		}
+
+		// Skip pending transactions, their amount may still change
+		if opts.SkipPending && strings.EqualFold(strings.TrimSpace(field(row, statusIdx)), "pending") {
+			summary.Pending++
+			logger.Debugf("Skipping line %d: pending", rowLine)
+			continue
+		}
+
+		// Extract payee, joining the non-empty payee columns if asked to
+		rawPayee := row[payeeIdx]
+		if len(payeeIndices) > 1 {
+			var parts []string
+			for _, idx := range payeeIndices {
+				if part := strings.TrimSpace(field(row, idx)); part != "" {
+					parts = append(parts, part)
+				}
+			}
+			rawPayee = strings.Join(parts, opts.PayeeJoin)
+		}
+
+		// Swap in the memo column as the payee when it holds the merchant
+		// name, keeping the payee in the memo. Rows without a memo keep
+		// their payee.
+		memo := text(row, memoIdx)
+		if opts.PayeeFromMemo && memo != "" {
+			rawPayee, memo = memo, rawPayee
+			if !opts.KeepWhitespace {
+				memo = collapseWhitespace(memo)
+			}
+		}
+
+		// Skip payments to the card, they're tracked from the paying account
+		if opts.DropPayments != nil && opts.DropPayments.MatchString(rawPayee) {
+			summary.Payments++
+			logger.Debugf("Skipping line %d: payment %q", rowLine, rawPayee)
+			continue
+		}
+
+		// Clean up the payee
+		payee := applyPayeeRules(rawPayee, opts.PayeeRules)
+		if !opts.KeepWhitespace {
+			payee = collapseWhitespace(payee)
+		}
+
+		// Cut off payees longer than YNAB keeps, optionally saving the rest
+		// in the memo
+		var payeeOverflow string
+		if opts.MaxPayeeLength > 0 && utf8.RuneCountInString(payee) > opts.MaxPayeeLength {
+			runes := []rune(payee)
+			payee = strings.TrimSpace(string(runes[:opts.MaxPayeeLength]))
+			if opts.PayeeOverflowToMemo {
+				payeeOverflow = strings.TrimSpace(string(runes[opts.MaxPayeeLength:]))
+			}
+		}
+
+		// Keep only the payees asked for
+		if (opts.Include != nil && !opts.Include.MatchString(payee)) || (opts.Exclude != nil && opts.Exclude.MatchString(payee)) {
+			summary.Filtered++
+			logger.Debugf("Skipping line %d: payee %q filtered out", rowLine, payee)
+			continue
+		}
+
+		// Build memo from the payee overflow and the memo parts, in order
+		var memoBuilder strings.Builder
+		memoBuilder.WriteString(payeeOverflow)
+		addMemo := func(part string) {
+			if memoBuilder.Len() > 0 {
+				memoBuilder.WriteString(memoSep)
+			}
+			memoBuilder.WriteString(part)
+		}
+		for _, memoField := range memoFields {
+			switch memoField {
+			case MemoInfo:
+				if memo != "" {
+					addMemo(memo)
+				}
+
+			case MemoRef:
+				// Combine the reference with a valid date into a sortable
+				// ID if requested
+				reference := text(row, referenceIdx)
+				if reference == "" {
+					continue
+				}
+				if parsed, err := time.Parse(DateLayout, date); opts.MemoRefDate && err == nil {
+					addMemo("ID: " + parsed.Format("20060102") + "-" + reference)
+				} else {
+					addMemo("Ref: " + reference)
+				}
+
+			case MemoLocation:
+				// Combine the location parts, or label them one by one
+				country := text(row, countryIdx)
+				if opts.ExpandCountries && country != "" {
+					country = expandCountry(country)
+				}
+				var location []string
+				for _, part := range []struct{ label, value string }{
+					{"City", text(row, locationIdx)},
+					{"Postcode", text(row, postcodeIdx)},
+					{"Country", country},
+				} {
+					if part.value == "" {
+						continue
+					}
+					if opts.LocationLabeled {
+						addMemo(part.label + ": " + part.value)
+						continue
+					}
+					location = append(location, part.value)
+				}
+				if len(location) > 0 {
+					addMemo("Location: " + strings.Join(location, ", "))
+				}
+
+			case MemoCategory:
+				if category := text(row, categoryIdx); category != "" {
+					addMemo("Category: " + category)
+				}
+
+			case MemoFX:
+				// The foreign purchase amount and currency
+				foreignAmount := text(row, foreignAmountIdx)
+				if foreignAmount == "" {
+					continue
+				}
+				if parsed, err := ParseAmountLocale(foreignAmount, opts.Locale); err == nil {
+					foreignAmount = fmt.Sprintf("%.2f", parsed)
+				}
+				if currency := text(row, foreignCurrencyIdx); currency != "" {
+					foreignAmount += " " + currency
+				}
+				addMemo("FX: " + foreignAmount)
+
+			case MemoMember:
+				// Who made the purchase on supplementary cards
+				if cardMember := text(row, cardMemberIdx); cardMember != "" {
+					addMemo("By: " + cardMember)
+				}
+			}
+		}
+
+		// Add the extra columns that have a value
+		for _, extra := range extraColumns {
+			value := text(row, extra.idx)
+			if value != "" {
+				addMemo(extra.name + ": " + value)
+			}
+		}
+
+		// Take the amount from its column, or from whichever of the debit
+		// and credit columns is filled in
+		rawAmount, rawAmountIdx := field(row, amountIdx), amountIdx
+		if splitAmounts {
+			rawAmount, rawAmountIdx = field(row, creditIdx), creditIdx
+			if debit := field(row, debitIdx); strings.TrimSpace(debit) != "" {
+				rawAmount, rawAmountIdx = debit, debitIdx
+			}
+		}
+
+		// Add the original amount if requested
+		if opts.KeepRawAmount && rawAmount != "" {
+			addMemo("Orig: " + rawAmount)
+		}
+
+		// Tag the memo with the source of this batch
+		if opts.Tag != "" {
+			addMemo(opts.Tag)
+		}
+
+		memo = memoBuilder.String()
+		if opts.OmitMemo {
+			memo = ""
+		}
+
+		// Reject amounts explicitly marked with another currency
+		if opts.Currency != "" {
+			if _, currency, err := parseMoney(rawAmount, opts.Locale); err == nil && currency != "" && currency != opts.Currency {
+				if opts.Strict && opts.Rejects == nil {
+					return &CurrencyError{Line: rowLine, Amount: rawAmount, Currency: currency, Expected: opts.Currency}
+				}
+				logger.Warnf("Line %d: amount %q is in %s, expected %s", rowLine, rawAmount, currency, opts.Currency)
+				summary.Rejected++
+				if err := c.reject(header, row); err != nil {
+					return fmt.Errorf("line %d: %w", rowLine, err)
+				}
+				continue
+			}
+		}
+
+		// Drop rows without an amount, or keep them with the amount blank
+		emptyAmount := strings.TrimSpace(rawAmount) == ""
+		if emptyAmount {
+			summary.EmptyAmounts++
+			if !opts.KeepEmptyAmount {
+				logger.Debugf("Skipping line %d: no amount", rowLine)
+				continue
+			}
+		}
+
+		// Apply YNAB's sign convention to the amount, or the sign the
+		// debit/credit indicator gives it. Debit and credit columns net out
+		// to credit minus debit.
+		var amount float64
+		var amountErr error
+		if emptyAmount {
+			amountErr = errEmptyAmount
+		} else if splitAmounts {
+			amount, amountErr = opts.netAmount(field(row, debitIdx), field(row, creditIdx))
+		} else {
+			amount, amountErr = opts.ynabAmount(rawAmount, field(row, indicatorIdx))
+		}
+		if amountErr == nil {
+			amount = applySignRules(amount, rawPayee, opts.SignRules)
+		}
+		amountLine := rowLine
+		if rawAmountIdx >= 0 && rawAmountIdx < len(row) {
+			amountLine, _ = reader.FieldPos(rawAmountIdx)
+		}
+		if amountErr == nil {
+			logger.Debugf("Line %d: amount %q converted to %s", amountLine, rawAmount, formatYNABAmount(amount))
+		}
+
+		// Skip zero amounts such as authorization holds
+		if opts.DropZero && amountErr == nil && amount == 0 {
+			summary.Zero++
+			logger.Debugf("Skipping line %d: zero amount", rowLine)
+			continue
+		}
+		t := transaction{
+			Date:      date,
+			Payee:     payee,
+			Memo:      memo,
+			Amount:    amount,
+			AmountErr: amountErr,
+			RawAmount: rawAmount,
+			Account:   text(row, accountIdx),
+		}
+
+		// Skip rows that were already converted in this run
+		if opts.Dedup {
+			formattedAmount := t.RawAmount
+			if amountErr == nil {
+				formattedAmount = formatYNABAmount(amount)
+			}
+			if c.isDuplicate(map[string]string{
+				"date":      date,
+				"payee":     payee,
+				"amount":    formattedAmount,
+				"memo":      memo,
+				"reference": field(row, referenceIdx),
+			}) {
+				summary.Duplicates++
+				logger.Debugf("Skipping line %d: duplicate", rowLine)
+				continue
+			}
+		}
+
+		// Report dates and amounts that couldn't be parsed, aborting in
+		// strict mode
+		badAmount := amountErr != nil && !emptyAmount
+		if dateErr != nil {
+			line, _ := reader.FieldPos(dateIdx)
+			if opts.Strict && opts.Rejects == nil {
+				return &ParseError{Line: line, Field: "date", Value: row[dateIdx], Err: dateErr}
+			}
+			logger.Warnf("Line %d: date %q could not be parsed", line, row[dateIdx])
+			c.problem(ProblemDate, line, row[dateIdx])
+		}
+		if badAmount {
+			if opts.Strict && opts.Rejects == nil {
+				return &ParseError{Line: amountLine, Field: "amount", Value: rawAmount, Err: amountErr}
+			}
+			logger.Debugf("Line %d: amount %q could not be parsed: %v", amountLine, rawAmount, amountErr)
+			c.problem(ProblemAmount, amountLine, rawAmount)
+		}
+
+		// Record rows that didn't convert cleanly, leaving them out in strict
+		// mode and from JSON output
+		if badAmount || dateErr != nil {
+			if err := c.reject(header, row); err != nil {
+				return fmt.Errorf("line %d: %w", rowLine, err)
+			}
+			if opts.Strict || opts.OutputFormat == OutputJSON {
+				summary.Rejected++
+				logger.Debugf("Skipping line %d: rejected", rowLine)
+				continue
+			}
+		}
+		if dateErr != nil {
+			summary.DateErrors++
+		}
+
+		// Write the YNAB row
+		if err := c.out.writeTransaction(t); err != nil {
+			return fmt.Errorf("failed to write line %d: %w", rowLine, err)
+		}
+
+		// Track totals using the YNAB signed amount
+		summary.Transactions++
+		if dateErr == nil {
+			summary.addDate(date)
+		}
+		// Flush regularly so large conversions don't build up output
+		if summary.Transactions%flushInterval == 0 {
+			if err := c.out.flush(); err != nil {
+				return fmt.Errorf("failed to write output at line %d: %w", rowLine, err)
+			}
+		}
+		if amountErr == nil {
+			summary.addAmount(amount)
+		} else if !emptyAmount {
+			summary.Skipped++
+		}
+	}
+
+	return nil
+}
+
+// extraColumn is an input column added to the memo by Options.ExtraColumns
+type extraColumn struct {
+	name string
+	idx  int
+}
+
+// field returns row[idx], or an empty string when the column wasn't found or
+// the row is too short to have it
+func field(row []string, idx int) string {
+	if idx < 0 || idx >= len(row) {
+		return ""
+	}
+	return row[idx]
+}
+
+// utf8BOM is the byte order mark Excel writes at the start of UTF-8 files
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// lineBreaks replaces every line break with a single space
+var lineBreaks = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ")
+
+// singleLine replaces the line breaks in a value with spaces
+func singleLine(value string) string {
+	if !strings.ContainsAny(value, "\r\n") {
+		return value
+	}
+	return lineBreaks.Replace(value)
+}
+
+// collapseWhitespace trims a value and collapses internal runs of whitespace
+// to a single space
+func collapseWhitespace(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// skipBOM discards a leading UTF-8 BOM from input, if there is one
+func skipBOM(input *bufio.Reader) {
+	// Inputs shorter than a BOM return an error from Peek and can't start with one
+	if peeked, err := input.Peek(len(utf8BOM)); err == nil && bytes.Equal(peeked, utf8BOM) {
+		input.Discard(len(utf8BOM))
+	}
+}
+
+// skipLines reads and discards n lines from input
+func skipLines(input *bufio.Reader, n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := input.ReadString('\n'); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// autoHeaderLines is the number of lines findHeaderLine considers
+const autoHeaderLines = 20
+
+// minHeaderScore is the number of known columns a line must name to be
+// picked as the header
+const minHeaderScore = 2
+
+// findHeaderLine peeks at the first lines of the input and returns the index
+// of the one naming the most columns of lookups, or 0 when none names at
+// least minHeaderScore
+func findHeaderLine(input *bufio.Reader, lookups []columnLookup) int {
+	// Peek may return fewer bytes together with an error for short inputs
+	peeked, _ := input.Peek(input.Size())
+	lines := strings.SplitN(string(peeked), "\n", autoHeaderLines+1)
+	lines = lines[:min(len(lines), autoHeaderLines)]
+
+	best, bestScore := 0, minHeaderScore-1
+	for i, line := range lines {
+		// Split on any of the delimiters, the real one isn't known yet
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ';' || r == '\t'
+		})
+		for j, name := range fields {
+			fields[j] = strings.Trim(strings.TrimSpace(name), `"`)
+		}
+
+		score := 0
+		columns := newHeaderIndex(fields)
+		for _, lookup := range lookups {
+			if columns.find(lookup.Aliases) != -1 {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// detectDelimiter peeks at the first line of the input and returns whichever
+// of comma, semicolon or tab occurs most often, or fallback when none does
+func detectDelimiter(input *bufio.Reader, fallback rune) rune {
+	// Peek may return fewer bytes together with an error for short inputs
+	peeked, _ := input.Peek(4096)
+	firstLine := string(peeked)
+	if i := strings.IndexByte(firstLine, '\n'); i != -1 {
+		firstLine = firstLine[:i]
+	}
+
+	delimiter := fallback
+	maxCount := 0
+	for _, candidate := range []rune{',', ';', '\t'} {
+		if count := strings.Count(firstLine, string(candidate)); count > maxCount {
+			delimiter = candidate
+			maxCount = count
+		}
+	}
+	return delimiter
+}
+
+// localeSampleBytes is how much of the input LocaleAuto samples amounts from,
+// and so the size of the input buffer
+const localeSampleBytes = 64 * 1024
+
+// sampleColumns returns the values of columns in the rows input has buffered,
+// without consuming them. The last row may be cut off by the end of the
+// buffer and is left out.
+func sampleColumns(input *bufio.Reader, comma rune, columns []int) []string {
+	peeked, _ := input.Peek(localeSampleBytes)
+	if i := bytes.LastIndexByte(peeked, '\n'); i != -1 && len(peeked) == localeSampleBytes {
+		peeked = peeked[:i+1]
+	}
+
+	reader := csv.NewReader(bytes.NewReader(peeked))
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	var values []string
+	for {
+		row, err := reader.Read()
+		if err != nil {
+			return values
+		}
+		for _, idx := range columns {
+			if value := field(row, idx); strings.TrimSpace(value) != "" {
+				values = append(values, value)
+			}
+		}
+	}
+}
+
+// detectLocale returns the locale whose decimal separator most amounts
+// show, or "" if none do. An amount shows its decimal separator when it has
+// both a comma and a dot, the last being decimal, or a single separator
+// followed by one or two digits.
+func detectLocale(amounts []string) string {
+	votes := map[string]int{}
+	for _, amount := range amounts {
+		lastComma := strings.LastIndexByte(amount, ',')
+		lastDot := strings.LastIndexByte(amount, '.')
+		switch {
+		case lastComma != -1 && lastDot != -1 && lastComma > lastDot:
+			votes[LocaleNL]++
+		case lastComma != -1 && lastDot != -1:
+			votes[LocaleEN]++
+		case lastComma != -1 && strings.Count(amount, ",") == 1 && decimalDigits(amount[lastComma+1:]):
+			votes[LocaleNL]++
+		case lastDot != -1 && strings.Count(amount, ".") == 1 && decimalDigits(amount[lastDot+1:]):
+			votes[LocaleEN]++
+		}
+	}
+	switch {
+	case votes[LocaleNL] > votes[LocaleEN]:
+		return LocaleNL
+	case votes[LocaleEN] > votes[LocaleNL]:
+		return LocaleEN
+	}
+	return ""
+}
+
+// decimalDigits reports whether the text after a separator starts with one
+// or two digits and no more, as cents do and thousands groups don't
+func decimalDigits(rest string) bool {
+	digits := len(rest) - len(strings.TrimLeft(rest, "0123456789"))
+	return digits == 1 || digits == 2
+}
+
+// columnLookup ties a mapped field to the variable receiving its column index
+type columnLookup struct {
+	Field   string
+	Aliases []string
+	Index   *int
+}
+
+// findColumns sets the index of every lookup to its exactly matching column.
+// With fuzzy set, fields that weren't found then fall back to the first
+// header containing one of their aliases, skipping columns that were already
+// matched so exact matches always win.
+func findColumns(header []string, columns headerIndex, lookups []columnLookup, fuzzy bool) {
+	claimed := map[int]bool{}
+	for _, lookup := range lookups {
+		*lookup.Index = columns.find(lookup.Aliases)
+		if *lookup.Index != -1 {
+			claimed[*lookup.Index] = true
+		}
+	}
+	if !fuzzy {
+		return
+	}
+
+	for _, lookup := range lookups {
+		if *lookup.Index != -1 {
+			continue
+		}
+		*lookup.Index = findFuzzyColumnIndex(header, lookup.Aliases, claimed)
+		if *lookup.Index != -1 {
+			claimed[*lookup.Index] = true
+		}
+	}
+}
+
+// overrideColumns points the lookups named in overrides, by lowercase field
+// name, at the given column index
+func overrideColumns(header []string, lookups []columnLookup, overrides map[string]int) error {
+	for field, idx := range overrides {
+		i := slices.IndexFunc(lookups, func(lookup columnLookup) bool {
+			return strings.EqualFold(lookup.Field, field)
+		})
+		if i == -1 {
+			return fmt.Errorf("unknown column override field %q", field)
+		}
+		if idx < 0 || idx >= len(header) {
+			return fmt.Errorf("column override %s=%d is out of range, the header has %d columns", field, idx, len(header))
+		}
+		*lookups[i].Index = idx
+	}
+	return nil
+}
+
+// findFuzzyColumnIndex returns the index of the first unclaimed header that
+// contains the earliest of possibleNames, ignoring case, or -1. Like
+// FindColumnIndex, the order of the names decides over the order of the
+// columns.
+func findFuzzyColumnIndex(header []string, possibleNames []string, claimed map[int]bool) int {
+	for _, name := range possibleNames {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		for i, h := range header {
+			if !claimed[i] && strings.Contains(strings.ToLower(h), name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// requiredColumn is a mapped field that must be present in the input header
+type requiredColumn struct {
+	Field   string
+	Index   int
+	Aliases []string
+}
+
+// checkRequiredColumns returns a *MissingColumnsError naming every required column that
+// wasn't found, the aliases that were tried and the columns the header has
+func checkRequiredColumns(header []string, columns []requiredColumn) error {
+	missing := &MissingColumnsError{Header: header}
+	for _, column := range columns {
+		if column.Index == -1 {
+			missing.Fields = append(missing.Fields, column.Field)
+			missing.Aliases = append(missing.Aliases, column.Aliases)
+		}
+	}
+	if len(missing.Fields) == 0 {
+		return nil
+	}
+	return missing
+}
+
+// CreateColumnMapper returns the column names of the DefaultProfile, the
+// known Dutch and English Amex column names
+func CreateColumnMapper() ColumnMapper {
+	profile, _ := LookupProfile(DefaultProfile)
+	return profile.Mapper.clone()
+}
+
+// clone returns a copy of m that shares no column name slices with it
+func (m ColumnMapper) clone() ColumnMapper {
+	for _, field := range m.columnEnvFields() {
+		*field = slices.Clone(*field)
+	}
+	return m
+}
+
+// LoadColumnMapper reads a JSON column mapping from path. Keys missing from
+// the file keep their built-in defaults.
+func LoadColumnMapper(path string) (ColumnMapper, error) {
+	mapper := CreateColumnMapper()
+	if err := mapper.LoadInto(path); err != nil {
+		return ColumnMapper{}, err
+	}
+	return mapper, nil
+}
+
+// LoadInto reads a JSON column mapping from path into m. Keys missing from
+// the file keep the names m already has.
+func (m *ColumnMapper) LoadInto(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// ColumnEnvPrefix starts the names of the environment variables that set
+// ColumnMapper fields
+const ColumnEnvPrefix = "AMEX2YNAB_"
+
+// columnEnvFields returns each ColumnMapper field by the environment
+// variable that sets it: ColumnEnvPrefix followed by the field's JSON key in
+// upper snake case, e.g. AMEX2YNAB_DATE_COLUMNS for dateColumns
+func (m *ColumnMapper) columnEnvFields() map[string]*[]string {
+	fields := map[string]*[]string{}
+	value := reflect.ValueOf(m).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		key := value.Type().Field(i).Tag.Get("json")
+		var name strings.Builder
+		for _, r := range key {
+			if unicode.IsUpper(r) {
+				name.WriteByte('_')
+			}
+			name.WriteRune(unicode.ToUpper(r))
+		}
+		fields[ColumnEnvPrefix+name.String()] = value.Field(i).Addr().Interface().(*[]string)
+	}
+	return fields
+}
+
+// ColumnEnvVars returns the names of the environment variables ApplyEnv
+// reads, sorted
+func ColumnEnvVars() []string {
+	var m ColumnMapper
+	var names []string
+	for name := range m.columnEnvFields() {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ApplyEnv replaces every field of m whose environment variable is set,
+// see ColumnEnvVars, with the comma-separated column names it holds. lookup
+// reads the environment, such as os.LookupEnv. It returns the names of the
+// variables that were applied, sorted.
+func (m *ColumnMapper) ApplyEnv(lookup func(key string) (string, bool)) []string {
+	var applied []string
+	for name, field := range m.columnEnvFields() {
+		value, ok := lookup(name)
+		if !ok || strings.TrimSpace(value) == "" {
+			continue
+		}
+		var columns []string
+		for _, column := range strings.Split(value, ",") {
+			if column = strings.TrimSpace(column); column != "" {
+				columns = append(columns, column)
+			}
+		}
+		*field = columns
+		applied = append(applied, name)
+	}
+	slices.Sort(applied)
+	return applied
+}
+
+// FindColumnIndex returns the index of the header matching the earliest of
+// possibleNames, ignoring case and surrounding whitespace, or -1. When
+// several names match, their order decides, not the order of the columns.
+func FindColumnIndex(header []string, possibleNames []string) int {
+	return newHeaderIndex(header).find(possibleNames)
+}
+
+// headerIndex maps each column name of a header, lowercased and trimmed, to
+// the indices of the columns with that name in header order. Building it
+// once saves normalizing the header again for every field that's looked up.
+type headerIndex map[string][]int
+
+// normalizeColumnName returns the form column names are compared in
+func normalizeColumnName(name string) string {
+	return strings.TrimSpace(strings.ToLower(name))
+}
+
+// newHeaderIndex indexes the column names of header
+func newHeaderIndex(header []string) headerIndex {
+	index := make(headerIndex, len(header))
+	for i, h := range header {
+		name := normalizeColumnName(h)
+		index[name] = append(index[name], i)
+	}
+	return index
+}
+
+// find returns the first column named like the earliest of possibleNames
+// that the header has, or -1
+func (h headerIndex) find(possibleNames []string) int {
+	for _, name := range possibleNames {
+		if indices := h[normalizeColumnName(name)]; len(indices) > 0 {
+			return indices[0]
+		}
+	}
+	return -1
+}
+
+// count returns how many columns are named name
+func (h headerIndex) count(name string) int {
+	return len(h[normalizeColumnName(name)])
+}
+
+// findAll returns the index of every column named like one of possibleNames,
+// ordered like possibleNames
+func (h headerIndex) findAll(possibleNames []string) []int {
+	var indices []int
+	for _, name := range possibleNames {
+		for _, i := range h[normalizeColumnName(name)] {
+			if !slices.Contains(indices, i) {
+				indices = append(indices, i)
+			}
+		}
+	}
+	return indices
+}
+
+// FormatDate normalizes an Amex date to YYYY-MM-DD like the conversion does,
+// trying InputDateLayouts first and dropping any time of day
+func (o Options) FormatDate(dateStr string) string {
+	if t, err := parseDateLayouts(dateStr, o.InputDateLayouts); err == nil {
+		// Format as YYYY-MM-DD (year-month-day)
+		return fmt.Sprintf("%04d-%02d-%02d", t.Year(), t.Month(), t.Day())
+	}
+
+	// If no format matches, return the original string
+	// This is not ideal but allows the process to continue
+	return dateStr
+}
+
+// parseDateLayouts parses a date with the given layouts first, falling back
+// to the ones parseDate knows
+func parseDateLayouts(dateStr string, layouts []string) (time.Time, error) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, dateStr); err == nil {
+			return t, nil
+		}
+	}
+
+	// Try different date formats
+	formats := []string{
+		"01/02/2006",          // MM/DD/YYYY
+		"02/01/2006",          // DD/MM/YYYY
+		time.RFC3339,          // YYYY-MM-DDTHH:mm:ss+hh:mm
+		"2006-01-02T15:04:05", // YYYY-MM-DDTHH:mm:ss
+		"2006-01-02 15:04:05", // YYYY-MM-DD HH:mm:ss
+		DateLayout,            // YYYY-MM-DD
+	}
+
+	for _, format := range formats {
+		if t, err := time.Parse(format, dateStr); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", dateStr)
+}
+
+// InvertAmount converts an Amex amount to a YNAB amount with two decimals
+// like the conversion does, so charges become outflows unless KeepSign is
+// set, honoring Locale and Rounding
+func (o Options) InvertAmount(amountStr string) string {
+	amount, err := o.ynabAmount(amountStr, "")
+	if err != nil {
+		// Return original if parsing fails
+		return amountStr
+	}
+	return formatYNABAmount(amount)
+}
+
+// SplitAmount converts the amount like InvertAmount, but returns its absolute
+// value in either the outflow or the inflow position depending on the sign
+func (o Options) SplitAmount(amountStr string) (outflow string, inflow string) {
+	amount, err := o.ynabAmount(amountStr, "")
+	if err != nil {
+		// Keep the original in the outflow column if parsing fails
+		return amountStr, ""
+	}
+	return splitYNABAmount(amount)
+}
+
+// formatYNABAmount formats a YNAB signed amount with 2 decimal places
+func formatYNABAmount(amount float64) string {
+	formatted := fmt.Sprintf("%.2f", amount)
+	// Negative zero, and negative amounts that round to zero, print as
+	// "-0.00"
+	if formatted == "-0.00" {
+		return "0.00"
+	}
+	return formatted
+}
+
+// Rounding modes supported by Options.Rounding
+const (
+	RoundHalfEven = "half-even"
+	RoundHalfUp   = "half-up"
+	RoundTruncate = "truncate"
+)
+
+// roundCents rounds an amount to two decimals. The rounding looks at the
+// shortest decimal form of the amount, so 1.005 is a tie as written on the
+// statement even though its binary value is slightly below it. RoundHalfUp
+// rounds ties away from zero, RoundTruncate drops the extra decimals and
+// anything else rounds ties to the even cent.
+func roundCents(amount float64, mode string) float64 {
+	digits := strconv.FormatFloat(math.Abs(amount), 'f', -1, 64)
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if len(fraction) <= 2 {
+		return amount
+	}
+	kept, rest := fraction[:2], fraction[2:]
+
+	var up bool
+	switch mode {
+	case RoundTruncate:
+		up = false
+	case RoundHalfUp:
+		up = rest[0] >= '5'
+	default:
+		// The shortest form has no trailing zeros, so a lone 5 is a tie
+		up = rest > "5" || rest == "5" && (kept[1]-'0')%2 == 1
+	}
+
+	cents, err := strconv.ParseInt(whole+kept, 10, 64)
+	if err != nil {
+		return amount
+	}
+	if up {
+		cents++
+	}
+	return math.Copysign(float64(cents)/100, amount)
+}
+
+// formatMilliunits formats a YNAB signed amount as integer milliunits, the
+// amount times 1000 rounded to the nearest integer
+func formatMilliunits(amount float64) string {
+	return strconv.FormatInt(int64(math.Round(amount*1000)), 10)
+}
+
+// splitYNABAmount returns the absolute value of a YNAB signed amount in the
+// outflow position when negative and in the inflow position otherwise
+func splitYNABAmount(amount float64) (outflow string, inflow string) {
+	if amount < 0 {
+		return formatYNABAmount(-amount), ""
+	}
+	return "", formatYNABAmount(amount)
+}
+
+// ParseAmount parses an Amex amount in either US or European notation
+func ParseAmount(amountStr string) (float64, error) {
+	return ParseAmountLocale(amountStr, "")
+}
+
+// ParseAmountLocale parses an amount using the decimal separator of locale,
+// so "1.234,56" is 1234.56 under LocaleNL and "1,234.56" is under LocaleEN
+func ParseAmountLocale(amountStr string, locale string) (float64, error) {
+	amount, _, err := parseMoney(amountStr, locale)
+	return amount, err
+}
+
+// currencySymbols maps the currency symbols found in Amex exports to their ISO codes
+var currencySymbols = map[string]string{"$": "USD", "€": "EUR", "£": "GBP"}
+
+var (
+	// leadingCurrency matches a symbol or ISO code before the number, after an optional sign
+	leadingCurrency = regexp.MustCompile(`^([-+]?)\s*([$€£]|[A-Za-z]{3})\s*`)
+	// trailingCurrency matches a symbol or ISO code after the number, and a
+	// trailing sign after the currency
+	trailingCurrency = regexp.MustCompile(`\s*([$€£]|[A-Za-z]{3})\s*([-+]?)$`)
+	// numberPattern matches what may remain of an amount once the currency is stripped
+	numberPattern = regexp.MustCompile(`^[-+]?[\d.,]+$`)
+)
+
+// parseMoney parses an amount and returns the ISO code of the currency it was
+// marked with, if any. Currency symbols and codes are only recognized at
+// either end of the amount so values like "12USD34" are rejected.
+func parseMoney(amountStr string, locale string) (float64, string, error) {
+	cleanAmount := strings.TrimSpace(amountStr)
+
+	// Accounting style wraps negative amounts in parentheses, either around
+	// or inside the currency
+	cleanAmount, negative := stripParentheses(cleanAmount)
+
+	// Strip the currency from either end
+	var currencies []string
+	if match := leadingCurrency.FindStringSubmatch(cleanAmount); match != nil {
+		currencies = append(currencies, currencyCode(match[2]))
+		cleanAmount = match[1] + cleanAmount[len(match[0]):]
+	}
+	if match := trailingCurrency.FindStringSubmatch(cleanAmount); match != nil {
+		currencies = append(currencies, currencyCode(match[1]))
+		cleanAmount = cleanAmount[:len(cleanAmount)-len(match[0])] + match[2]
+	}
+	if len(currencies) == 2 && currencies[0] != currencies[1] {
+		return 0, "", fmt.Errorf("amount %q has conflicting currencies %s and %s", amountStr, currencies[0], currencies[1])
+	}
+	var currency string
+	if len(currencies) > 0 {
+		currency = currencies[0]
+	}
+
+	if !negative {
+		cleanAmount, negative = stripParentheses(cleanAmount)
+	}
+
+	// Remove spaces and make sure only the number is left. strings.Fields
+	// splits on every Unicode space, so the regular, non-breaking (U+00A0)
+	// and narrow non-breaking (U+202F) spaces French exports group
+	// thousands with, as in "1 234,56", all go.
+	cleanAmount = strings.Join(strings.Fields(cleanAmount), "")
+
+	// Some banking systems put the sign after the number, as in "12,34-"
+	if n := len(cleanAmount); n > 1 && strings.ContainsAny(cleanAmount[n-1:], "+-") && !strings.ContainsAny(cleanAmount[:1], "+-") {
+		cleanAmount = cleanAmount[n-1:] + cleanAmount[:n-1]
+	}
+	if !numberPattern.MatchString(cleanAmount) {
+		return 0, "", fmt.Errorf("invalid amount %q", amountStr)
+	}
+	if negative && strings.ContainsAny(cleanAmount[:1], "+-") {
+		return 0, "", fmt.Errorf("invalid amount %q: sign inside parentheses", amountStr)
+	}
+
+	// Parse the amount
+	normalized, err := normalizeDecimal(cleanAmount, locale)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid amount %q: %w", amountStr, err)
+	}
+	amount, err := strconv.ParseFloat(normalized, 64)
+	if err != nil {
+		return 0, "", err
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, currency, nil
+}
+
+// stripParentheses removes the parentheses around an amount and reports
+// whether there were any, marking it negative
+func stripParentheses(amount string) (string, bool) {
+	if len(amount) >= 2 && amount[0] == '(' && amount[len(amount)-1] == ')' {
+		return strings.TrimSpace(amount[1 : len(amount)-1]), true
+	}
+	return amount, false
+}
+
+// normalizeDecimal strips the thousands separators from number and makes a
+// dot its decimal separator. LocaleEN uses a dot as the decimal separator and
+// LocaleNL a comma. Without a locale the last of both separators is the
+// decimal one, a separator that repeats is a thousands separator and a
+// single one is decimal. Thousands separators must group the digits by three.
+func normalizeDecimal(number string, locale string) (string, error) {
+	sign := ""
+	if number != "" && (number[0] == '-' || number[0] == '+') {
+		sign, number = number[:1], number[1:]
+	}
+
+	decimal, thousands := decimalSeparators(number, locale)
+	whole, fraction, hasFraction := strings.Cut(number, string(decimal))
+	if strings.ContainsAny(fraction, ".,") {
+		return "", fmt.Errorf("more than one decimal separator")
+	}
+
+	// Check the grouping before dropping the thousands separators
+	groups := strings.Split(whole, string(thousands))
+	for i, group := range groups {
+		// A lone empty group is a fraction without leading zero, like ",5"
+		if group == "" && (len(groups) > 1 || !hasFraction) || len(group) > 3 && len(groups) > 1 || i > 0 && len(group) != 3 {
+			return "", fmt.Errorf("misplaced thousands separator")
+		}
+		if strings.ContainsAny(group, ".,") {
+			return "", fmt.Errorf("mixed separators")
+		}
+	}
+
+	normalized := sign + strings.Join(groups, "")
+	if hasFraction {
+		normalized += "." + fraction
+	}
+	return normalized, nil
+}
+
+// decimalSeparators returns the decimal and thousands separators of number
+// for locale, guessing them when locale is empty
+func decimalSeparators(number string, locale string) (decimal byte, thousands byte) {
+	switch locale {
+	case LocaleEN:
+		return '.', ','
+	case LocaleNL:
+		return ',', '.'
+	}
+
+	lastComma := strings.LastIndexByte(number, ',')
+	lastDot := strings.LastIndexByte(number, '.')
+	switch {
+	case lastComma != -1 && lastDot != -1 && lastComma > lastDot:
+		return ',', '.'
+	case lastComma != -1 && lastDot != -1:
+		return '.', ','
+	case strings.Count(number, ",") == 1:
+		return ',', '.'
+	case strings.Count(number, ",") > 1:
+		return '.', ','
+	case strings.Count(number, ".") > 1:
+		return ',', '.'
+	}
+	return '.', ','
+}
+
+// currencyCode returns the ISO code for a currency symbol or code
+func currencyCode(symbolOrCode string) string {
+	if code, ok := currencySymbols[symbolOrCode]; ok {
+		return code
+	}
+	return strings.ToUpper(symbolOrCode)
+}
@@ -0,0 +1,818 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testLogger sends conversion diagnostics to the test log
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Warnf(format string, args ...any) {
+	l.t.Logf("warning: "+format, args...)
+}
+
+func (l testLogger) Debugf(format string, args ...any) {}
+
+// convertString runs ProcessCSV over input and returns the output
+func convertString(t *testing.T, input string, opts Options) (string, Summary) {
+	t.Helper()
+	if opts.Logger == nil {
+		opts.Logger = testLogger{t}
+	}
+	var output bytes.Buffer
+	summary, err := ProcessCSV(strings.NewReader(input), &output, opts)
+	if err != nil {
+		t.Fatalf("ProcessCSV: %v", err)
+	}
+	return output.String(), summary
+}
+
+func TestProcessCSVSkipsBOM(t *testing.T) {
+	input := "\ufeffDatum,Omschrijving,Bedrag\n03/15/2024,ALBERT HEIJN,\"12,34\"\n"
+	got, summary := convertString(t, input, Options{})
+
+	want := "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if summary.Header[0] != "Datum" {
+		t.Errorf("first header column = %q, want %q", summary.Header[0], "Datum")
+	}
+}
+
+func TestParseAmountLocale(t *testing.T) {
+	tests := []struct {
+		amount string
+		locale string
+		want   float64
+	}{
+		{"1.234,56", LocaleNL, 1234.56},
+		{"-1.234,56", LocaleNL, -1234.56},
+		{"12,34", LocaleNL, 12.34},
+		{"-12,34", LocaleNL, -12.34},
+		{"1.234", LocaleNL, 1234},
+		{"1,234.56", LocaleEN, 1234.56},
+		{"-1,234.56", LocaleEN, -1234.56},
+		{"12.34", LocaleEN, 12.34},
+		{"-12.34", LocaleEN, -12.34},
+		{"1,234", LocaleEN, 1234},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmountLocale(tt.amount, tt.locale)
+		if err != nil {
+			t.Errorf("ParseAmountLocale(%q, %q): %v", tt.amount, tt.locale, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmountLocale(%q, %q) = %v, want %v", tt.amount, tt.locale, got, tt.want)
+		}
+	}
+}
+
+// generateDutchExport returns a Dutch export with rows transactions
+func generateDutchExport(rows int) []byte {
+	var input bytes.Buffer
+	input.WriteString("Datum,Omschrijving,Bedrag,Aanvullende informatie,Referentie,Plaats,Postcode,Land\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&input, "%02d/%02d/2024,MERCHANT %d,\"%d,%02d\",info %d,R%d,Amsterdam,1011AB,NL\n",
+			i%12+1, i%28+1, i%500, i%2000, i%100, i, i)
+	}
+	return input.Bytes()
+}
+
+func BenchmarkProcessCSV(b *testing.B) {
+	input := generateDutchExport(100000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ProcessCSV(bytes.NewReader(input), io.Discard, Options{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProcessCSVTabDelimited(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "dutch.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Date,Payee,Memo,Amount\n" +
+		"2024-03-15,ALBERT HEIJN,\"info | Ref: R1 | Location: Amsterdam, 1011AB, NL\",-12.34\n" +
+		"2024-03-16,REFUND,,5.00\n"
+	for _, delimiter := range []rune{0, '\t'} {
+		got, _ := convertString(t, string(input), Options{Delimiter: delimiter})
+		if got != want {
+			t.Errorf("delimiter %q: output = %q, want %q", delimiter, got, want)
+		}
+	}
+}
+
+func TestFuzzyColumns(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "substring match",
+			input: "Datum,Omschrijving transactie,Bedrag\n03/15/2024,ALBERT HEIJN,\"12,34\"\n",
+			want:  "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n",
+		},
+		{
+			name:  "exact match wins over an earlier substring match",
+			input: "Datum,Omschrijving transactie,Omschrijving,Bedrag\n03/15/2024,AH 1234 AMS,ALBERT HEIJN,\"12,34\"\n",
+			want:  "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n",
+		},
+		{
+			name:  "exact match of another field isn't taken",
+			input: "Datum,Bedrag,Omschrijving transactie,Bedrag in vreemde valuta\n03/15/2024,\"12,34\",ALBERT HEIJN,\"13,00\"\n",
+			want:  "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,FX: 13.00,-12.34\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := convertString(t, tt.input, Options{FuzzyColumns: true})
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Without FuzzyColumns the payee column isn't found
+	input := "Datum,Omschrijving transactie,Bedrag\n03/15/2024,ALBERT HEIJN,\"12,34\"\n"
+	_, err := ProcessCSV(strings.NewReader(input), io.Discard, Options{Logger: testLogger{t}})
+	if !errors.Is(err, ErrMissingColumns) {
+		t.Errorf("without FuzzyColumns: error = %v, want ErrMissingColumns", err)
+	}
+}
+
+func TestInvertAmount(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   string
+	}{
+		{"12.34", "-12.34"},
+		{"12,34", "-12.34"},
+		{"1.234,56", "-1234.56"},
+		{"1,234.56", "-1234.56"},
+		{"€12,34", "-12.34"},
+		{"-5,00", "5.00"},
+		{"abc", "abc"},
+	}
+	for _, tt := range tests {
+		if got := (Options{}).InvertAmount(tt.amount); got != tt.want {
+			t.Errorf("InvertAmount(%q) = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestNewDecoder(t *testing.T) {
+	tests := []struct {
+		encoding string
+		input    []byte
+		want     string
+	}{
+		{EncodingUTF8, []byte("Café €"), "Café €"},
+		{EncodingWindows1252, []byte{'C', 'a', 'f', 0xE9, ' ', 0x80}, "Café €"},
+		{EncodingISO88591, []byte{'C', 'a', 'f', 0xE9}, "Café"},
+	}
+	for _, tt := range tests {
+		decoder, err := NewDecoder(bytes.NewReader(tt.input), tt.encoding)
+		if err != nil {
+			t.Fatalf("NewDecoder(%q): %v", tt.encoding, err)
+		}
+		got, err := io.ReadAll(decoder)
+		if err != nil {
+			t.Fatalf("reading %s: %v", tt.encoding, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: decoded %q, want %q", tt.encoding, got, tt.want)
+		}
+	}
+
+	if _, err := NewDecoder(strings.NewReader(""), "utf-16"); err == nil {
+		t.Error("NewDecoder(utf-16) succeeded, want an error")
+	}
+}
+
+func TestProcessCSVJSONLeavesOutUnparsedRows(t *testing.T) {
+	input := "Datum,Omschrijving,Bedrag\n03/15/2024,A,\"12,34\"\n03/16/2024,B,abc\nxx,C,\"1,00\"\n"
+	var rejects bytes.Buffer
+	got, summary := convertString(t, input, Options{OutputFormat: OutputJSON, Rejects: &rejects})
+
+	want := "[\n" +
+		`{"date":"2024-03-15","payee_name":"A","memo":"","amount":-12340,"import_id":"AMEX:2024-03-15:-12340:1"}` +
+		"\n]\n"
+	if got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if summary.Rejected != 2 || summary.Transactions != 1 {
+		t.Errorf("rejected %d and wrote %d, want 2 and 1", summary.Rejected, summary.Transactions)
+	}
+	if want := "Datum,Omschrijving,Bedrag\n03/16/2024,B,abc\nxx,C,\"1,00\"\n"; rejects.String() != want {
+		t.Errorf("rejects = %q, want %q", rejects.String(), want)
+	}
+}
+
+func TestProcessCSVMultiLineFields(t *testing.T) {
+	input, err := os.ReadFile(filepath.Join("testdata", "multiline.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Date,Payee,Memo,Amount\n" +
+		"2024-03-15,ALBERT HEIJN,Line one Line two,-12.34\n" +
+		"2024-03-16,BOL.COM B.V.,info,-5.00\n"
+	for _, keepWhitespace := range []bool{false, true} {
+		got, _ := convertString(t, string(input), Options{KeepWhitespace: keepWhitespace})
+		if got != want {
+			t.Errorf("KeepWhitespace %v: output = %q, want %q", keepWhitespace, got, want)
+		}
+	}
+}
+
+func TestFindColumnIndexPrefersAliasOrder(t *testing.T) {
+	header := []string{"Datum", "Omschrijving", "Bedrag", "Verschijnt op uw rekeningoverzicht als"}
+	tests := []struct {
+		aliases []string
+		want    int
+	}{
+		{[]string{"Verschijnt op uw rekeningoverzicht als", "Omschrijving"}, 3},
+		{[]string{"Omschrijving", "Verschijnt op uw rekeningoverzicht als"}, 1},
+		{[]string{"Description", "Omschrijving"}, 1},
+		{[]string{"Description"}, -1},
+	}
+	for _, tt := range tests {
+		if got := FindColumnIndex(header, tt.aliases); got != tt.want {
+			t.Errorf("FindColumnIndex(%q) = %d, want %d", tt.aliases, got, tt.want)
+		}
+	}
+}
+
+func TestRoundCents(t *testing.T) {
+	tests := []struct {
+		amount float64
+		mode   string
+		want   string
+	}{
+		{1.005, RoundHalfEven, "1.00"},
+		{2.675, RoundHalfEven, "2.68"},
+		{-2.675, RoundHalfEven, "-2.68"},
+		{1.0051, RoundHalfEven, "1.01"},
+		{1.005, RoundHalfUp, "1.01"},
+		{2.675, RoundHalfUp, "2.68"},
+		{-1.005, RoundHalfUp, "-1.01"},
+		{1.004, RoundHalfUp, "1.00"},
+		{1.005, RoundTruncate, "1.00"},
+		{2.675, RoundTruncate, "2.67"},
+		{-2.679, RoundTruncate, "-2.67"},
+		{12.5, RoundHalfUp, "12.50"},
+	}
+	for _, tt := range tests {
+		if got := formatYNABAmount(roundCents(tt.amount, tt.mode)); got != tt.want {
+			t.Errorf("roundCents(%v, %q) = %s, want %s", tt.amount, tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestInvertAmountDefaultRounding(t *testing.T) {
+	// The default rounds the amount as written on the statement, so 2.675
+	// is a tie rounded to the even cent, where %.2f of its binary value
+	// would give 2.67
+	if got := (Options{}).InvertAmount("2.675"); got != "-2.68" {
+		t.Errorf("InvertAmount(2.675) = %q, want %q", got, "-2.68")
+	}
+	if got := (Options{}).InvertAmount("1.005"); got != "-1.00" {
+		t.Errorf("InvertAmount(1.005) = %q, want %q", got, "-1.00")
+	}
+}
+
+func TestProcessCSVEmptyInput(t *testing.T) {
+	var output bytes.Buffer
+	_, err := ProcessCSV(strings.NewReader(""), &output, Options{Logger: testLogger{t}})
+	if !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("error = %v, want ErrEmptyInput", err)
+	}
+}
+
+func TestProcessCSVHeaderOnly(t *testing.T) {
+	got, summary := convertString(t, "Datum,Omschrijving,Bedrag\n", Options{})
+	if want := "Date,Payee,Memo,Amount\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if summary.Transactions != 0 {
+		t.Errorf("Transactions = %d, want 0", summary.Transactions)
+	}
+}
+
+func TestNormalizeDecimal(t *testing.T) {
+	tests := []struct {
+		number string
+		locale string
+		want   string
+	}{
+		{"1.234.567,89", LocaleNL, "1234567.89"},
+		{"-1.234.567,89", LocaleNL, "-1234567.89"},
+		{"1.234.567,89", "", "1234567.89"},
+		{"1,234,567.89", LocaleEN, "1234567.89"},
+		{"-1,234,567.89", LocaleEN, "-1234567.89"},
+		{"1,234,567.89", "", "1234567.89"},
+		{"1.234.567", LocaleNL, "1234567"},
+		{"1,234,567", LocaleEN, "1234567"},
+		{"+12,34", LocaleNL, "+12.34"},
+	}
+	for _, tt := range tests {
+		got, err := normalizeDecimal(tt.number, tt.locale)
+		if err != nil {
+			t.Errorf("normalizeDecimal(%q, %q): %v", tt.number, tt.locale, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("normalizeDecimal(%q, %q) = %q, want %q", tt.number, tt.locale, got, tt.want)
+		}
+	}
+
+	for _, number := range []string{"1.23.567,89", "1.234,567,89", "12.3456,78", "1,234.567.89"} {
+		if got, err := normalizeDecimal(number, LocaleNL); err == nil {
+			t.Errorf("normalizeDecimal(%q, nl) = %q, want an error", number, got)
+		}
+	}
+}
+
+func TestParseAmountLocaleCurrencyPrefixed(t *testing.T) {
+	tests := []struct {
+		amount string
+		locale string
+		want   float64
+	}{
+		{"€1.234.567,89", LocaleNL, 1234567.89},
+		{"-€1.234.567,89", LocaleNL, -1234567.89},
+		{"EUR 1.234.567,89", LocaleNL, 1234567.89},
+		{"$1,234,567.89", LocaleEN, 1234567.89},
+		{"-$1,234,567.89", LocaleEN, -1234567.89},
+		{"USD -1,234,567.89", LocaleEN, -1234567.89},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmountLocale(tt.amount, tt.locale)
+		if err != nil {
+			t.Errorf("ParseAmountLocale(%q, %q): %v", tt.amount, tt.locale, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmountLocale(%q, %q) = %v, want %v", tt.amount, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestParseAmountParentheses(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   float64
+	}{
+		{"(12.34)", -12.34},
+		{"(12,34)", -12.34},
+		{"($12.34)", -12.34},
+		{"$(12.34)", -12.34},
+		{"( 1,234.56 )", -1234.56},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmount(tt.amount)
+		if err != nil {
+			t.Errorf("ParseAmount(%q): %v", tt.amount, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmount(%q) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+
+	// The parenthesized amount is negative before inversion, so a credit
+	if got := (Options{}).InvertAmount("(12.34)"); got != "12.34" {
+		t.Errorf("InvertAmount(%q) = %q, want %q", "(12.34)", got, "12.34")
+	}
+}
+
+// recordingLogger keeps the warnings of a conversion
+type recordingLogger struct {
+	warnings []string
+}
+
+func (l *recordingLogger) Warnf(format string, args ...any) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...any) {}
+
+func TestProcessCSVDuplicateHeader(t *testing.T) {
+	input := "Datum,Omschrijving,Bedrag,Bedrag\n03/15/2024,ALBERT HEIJN,\"12,34\",\"13,50\"\n"
+
+	// The first column wins, with a warning naming the repeated name
+	logger := &recordingLogger{}
+	got, _ := convertString(t, input, Options{Logger: logger})
+	if want := "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], `"Bedrag" appears 2 times`) {
+		t.Errorf("warnings = %q, want one about Bedrag appearing twice", logger.warnings)
+	}
+
+	// An override picks the other column and silences the warning
+	logger = &recordingLogger{}
+	got, _ = convertString(t, input, Options{Logger: logger, ColumnOverrides: map[string]int{"amount": 3}})
+	if want := "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-13.50\n"; got != want {
+		t.Errorf("with override: output = %q, want %q", got, want)
+	}
+	if len(logger.warnings) != 0 {
+		t.Errorf("with override: warnings = %q, want none", logger.warnings)
+	}
+}
+
+func TestParseAmountTrailingMinus(t *testing.T) {
+	tests := []struct {
+		amount string
+		locale string
+		want   float64
+	}{
+		{"12,34-", LocaleNL, -12.34},
+		{"12,34-", "", -12.34},
+		{"1.234,56-", LocaleNL, -1234.56},
+		{"12.34-", LocaleEN, -12.34},
+		{"12,34+", LocaleNL, 12.34},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmountLocale(tt.amount, tt.locale)
+		if err != nil {
+			t.Errorf("ParseAmountLocale(%q, %q): %v", tt.amount, tt.locale, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmountLocale(%q, %q) = %v, want %v", tt.amount, tt.locale, got, tt.want)
+		}
+	}
+	if got := (Options{}).InvertAmount("12,34-"); got != "12.34" {
+		t.Errorf("InvertAmount(%q) = %q, want %q", "12,34-", got, "12.34")
+	}
+}
+
+func TestParseAmountNonBreakingSpace(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   float64
+	}{
+		{"1\u00a0234,56", 1234.56},
+		{"-1\u00a0234,56", -1234.56},
+		{"€\u00a012,34", 12.34},
+		{"1\u202f234,56", 1234.56},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmountLocale(tt.amount, LocaleNL)
+		if err != nil {
+			t.Errorf("ParseAmountLocale(%q, nl): %v", tt.amount, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmountLocale(%q, nl) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestInvertAmountZero(t *testing.T) {
+	// Inverting zero mustn't give YNAB a "-0.00"
+	for _, amount := range []string{"0,00", "0.00", "-0,00", "0,001", "-0,004"} {
+		if got := (Options{}).InvertAmount(amount); got != "0.00" {
+			t.Errorf("InvertAmount(%q) = %q, want %q", amount, got, "0.00")
+		}
+	}
+}
+
+func TestProcessCSVDutchDefaults(t *testing.T) {
+	input := "Datum,Omschrijving,Bedrag,Aanvullende informatie,Referentie,Plaats,Postcode,Land\n" +
+		"03/15/2024,ALBERT HEIJN,\"12,34\",info,X,City,1234AB,NL\n"
+	got, summary := convertString(t, input, Options{})
+
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output has %d lines, want 2: %q", len(lines), got)
+	}
+	if want := "Date,Payee,Memo,Amount"; lines[0] != want {
+		t.Errorf("header = %q, want %q", lines[0], want)
+	}
+	if want := `2024-03-15,ALBERT HEIJN,"info | Ref: X | Location: City, 1234AB, NL",-12.34`; lines[1] != want {
+		t.Errorf("row = %q, want %q", lines[1], want)
+	}
+	if summary.Transactions != 1 || summary.Outflow != 12.34 {
+		t.Errorf("summary has %d transactions and %v outflow, want 1 and 12.34", summary.Transactions, summary.Outflow)
+	}
+}
+
+func TestProcessCSVLineEndings(t *testing.T) {
+	input := "Datum,Omschrijving,Bedrag\n03/15/2024,ALBERT HEIJN,\"12,34\"\n"
+
+	got, _ := convertString(t, input, Options{CRLF: true})
+	if want := "Date,Payee,Memo,Amount\r\n2024-03-15,ALBERT HEIJN,,-12.34\r\n"; got != want {
+		t.Errorf("CRLF output = %q, want %q", got, want)
+	}
+
+	got, _ = convertString(t, input, Options{})
+	if strings.Contains(got, "\r") {
+		t.Errorf("default output = %q, want LF line endings only", got)
+	}
+}
+
+// findColumnIndexLoop is the double loop headerIndex replaced, kept to check
+// that both match the same columns
+func findColumnIndexLoop(header []string, possibleNames []string) int {
+	for _, name := range possibleNames {
+		name = strings.TrimSpace(strings.ToLower(name))
+		for i, h := range header {
+			if strings.TrimSpace(strings.ToLower(h)) == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// findAllColumnIndicesLoop is the double loop headerIndex.findAll replaced
+func findAllColumnIndicesLoop(header []string, possibleNames []string) []int {
+	var indices []int
+	for _, name := range possibleNames {
+		name = strings.TrimSpace(strings.ToLower(name))
+		for i, h := range header {
+			if strings.TrimSpace(strings.ToLower(h)) == name && !slices.Contains(indices, i) {
+				indices = append(indices, i)
+			}
+		}
+	}
+	return indices
+}
+
+// mapperAliases returns the aliases of every field of mapper
+func mapperAliases(mapper ColumnMapper) [][]string {
+	var aliases [][]string
+	for _, names := range mapper.columnEnvFields() {
+		aliases = append(aliases, *names)
+	}
+	return aliases
+}
+
+func TestHeaderIndexMatchesLoop(t *testing.T) {
+	headers := [][]string{
+		{"Datum", "Omschrijving", "Bedrag", "Aanvullende informatie", "Referentie", "Plaats", "Postcode", "Land"},
+		{" DATUM ", "omschrijving", "Bedrag ", "\tReferentie"},
+		{"Datum", "Bedrag", "Bedrag", "Omschrijving", "Omschrijving "},
+		{"Date", "Description", "Amount", "Verschijnt op uw rekeningoverzicht als", "Description"},
+		{"", "Datum", ""},
+		{},
+	}
+	aliases := append(mapperAliases(CreateColumnMapper()),
+		[]string{" bedrag ", "Amount"},
+		[]string{"Omschrijving", "Description", "Omschrijving"},
+		[]string{""},
+		nil,
+	)
+	for _, header := range headers {
+		index := newHeaderIndex(header)
+		for _, names := range aliases {
+			if got, want := index.find(names), findColumnIndexLoop(header, names); got != want {
+				t.Errorf("header %q: find(%q) = %d, want %d", header, names, got, want)
+			}
+			if got, want := index.findAll(names), findAllColumnIndicesLoop(header, names); !slices.Equal(got, want) {
+				t.Errorf("header %q: findAll(%q) = %v, want %v", header, names, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkFindColumnIndex(b *testing.B) {
+	header := []string{"Datum", "Omschrijving", "Kaartlid", "Rekening #", "Bedrag", "Aanvullende informatie",
+		"Verschijnt op uw rekeningoverzicht als", "Adres", "Plaats", "Postcode", "Land", "Referentie", "Categorie"}
+	aliases := mapperAliases(CreateColumnMapper())
+
+	b.Run("loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, names := range aliases {
+				findColumnIndexLoop(header, names)
+			}
+		}
+	})
+	b.Run("index", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			index := newHeaderIndex(header)
+			for _, names := range aliases {
+				index.find(names)
+			}
+		}
+	})
+}
+
+func TestProcessCSVLongPayee(t *testing.T) {
+	// 250 characters, the last 50 of them past the limit
+	head := strings.Repeat("A", 199) + "é"
+	tail := strings.Repeat("B", 50)
+	input := "Datum,Omschrijving,Bedrag,Aanvullende informatie\n" +
+		"03/15/2024," + head + tail + ",\"12,34\",info\n"
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"no limit", Options{}, "2024-03-15," + head + tail + ",info,-12.34\n"},
+		{"truncated", Options{MaxPayeeLength: 200}, "2024-03-15," + head + ",info,-12.34\n"},
+		{"overflow to memo", Options{MaxPayeeLength: 200, PayeeOverflowToMemo: true}, "2024-03-15," + head + "," + tail + " | info,-12.34\n"},
+	}
+	for _, tt := range tests {
+		got, _ := convertString(t, input, tt.opts)
+		if want := "Date,Payee,Memo,Amount\n" + tt.want; got != want {
+			t.Errorf("%s: output = %q, want %q", tt.name, got, want)
+		}
+	}
+}
+
+func TestParseAmountSignAndCurrency(t *testing.T) {
+	tests := []struct {
+		amount string
+		want   float64
+	}{
+		{"-€12,34", -12.34},
+		{"€-12,34", -12.34},
+		{"- € 12,34", -12.34},
+		{"12,34 €-", -12.34},
+		{"12,34€", 12.34},
+		{"-12,34 €", -12.34},
+		{"EUR 12,34 EUR", 12.34},
+		{"€ 12,34 €", 12.34},
+		{"-EUR 12,34", -12.34},
+		{"12,34 EUR-", -12.34},
+		{"+€12,34", 12.34},
+	}
+	for _, tt := range tests {
+		got, err := ParseAmountLocale(tt.amount, LocaleNL)
+		if err != nil {
+			t.Errorf("ParseAmountLocale(%q, nl): %v", tt.amount, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAmountLocale(%q, nl) = %v, want %v", tt.amount, got, tt.want)
+		}
+	}
+}
+
+func TestProcessCSVShortDebitCreditRow(t *testing.T) {
+	input := "Datum,Omschrijving,Af,Bij\n03/15/2024,ALBERT HEIJN,\"12,34\",\n03/16/2024,REF\n"
+
+	// The row ending before the debit column is skipped rather than read
+	got, summary := convertString(t, input, Options{})
+	if want := "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if summary.Malformed != 1 {
+		t.Errorf("Malformed = %d, want 1", summary.Malformed)
+	}
+}
+
+func TestProcessCSVKeepEmptyAmountShortRow(t *testing.T) {
+	// The first row stops after an empty debit column, the second before it
+	input := "Datum,Omschrijving,Af,Bij\n03/16/2024,REF,\n03/17/2024,REF\n"
+
+	got, summary := convertString(t, input, Options{KeepEmptyAmount: true})
+	if want := "Date,Payee,Memo,Amount\n2024-03-16,REF,,\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if summary.EmptyAmounts != 1 || summary.Malformed != 1 {
+		t.Errorf("EmptyAmounts = %d, Malformed = %d, want 1 and 1", summary.EmptyAmounts, summary.Malformed)
+	}
+}
+
+func TestProcessCSVRejectsOtherCurrency(t *testing.T) {
+	input := "Datum,Omschrijving,Bedrag\n03/15/2024,ALBERT HEIJN,\"EUR 12,34\"\n03/16/2024,HOTEL,\"USD 50,00\"\n03/17/2024,HEMA,\"5,00\"\n"
+
+	// The dollar row is rejected and the conversion goes on
+	var rejects bytes.Buffer
+	got, summary := convertString(t, input, Options{Currency: "EUR", Rejects: &rejects})
+	if want := "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n2024-03-17,HEMA,,-5.00\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if summary.Rejected != 1 {
+		t.Errorf("Rejected = %d, want 1", summary.Rejected)
+	}
+	if want := "Datum,Omschrijving,Bedrag\n03/16/2024,HOTEL,\"USD 50,00\"\n"; rejects.String() != want {
+		t.Errorf("rejects = %q, want %q", rejects.String(), want)
+	}
+
+	// Strict mode without rejects stops at the dollar row
+	_, err := ProcessCSV(strings.NewReader(input), io.Discard, Options{Currency: "EUR", Strict: true, Logger: testLogger{t}})
+	var currencyErr *CurrencyError
+	if !errors.As(err, &currencyErr) || currencyErr.Line != 3 || currencyErr.Currency != "USD" {
+		t.Errorf("strict: err = %v, want a CurrencyError for USD on line 3", err)
+	}
+}
+
+func TestProcessCSVFilteredRowsNotReported(t *testing.T) {
+	// The pending and the excluded row have a bad date or amount
+	input := "Datum,Omschrijving,Bedrag,Status\n" +
+		"15-03-2024,HOTEL,\"12,34\",Pending\n" +
+		"03/16/2024,HOTEL,abc,Pending\n" +
+		"15-03-2024,TRANSFER,\"5,00\",\n" +
+		"03/17/2024,HEMA,\"5,00\",\n"
+
+	var rejects bytes.Buffer
+	var problems []Problem
+	opts := Options{
+		SkipPending: true,
+		Exclude:     regexp.MustCompile("TRANSFER"),
+		Rejects:     &rejects,
+		OnProblem:   func(p Problem) { problems = append(problems, p) },
+	}
+	got, summary := convertString(t, input, opts)
+	if want := "Date,Payee,Memo,Amount\n2024-03-17,HEMA,,-5.00\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if summary.HadParseErrors() || summary.Pending != 2 || summary.Filtered != 1 {
+		t.Errorf("DateErrors = %d, Skipped = %d, Pending = %d, Filtered = %d, want 0, 0, 2 and 1", summary.DateErrors, summary.Skipped, summary.Pending, summary.Filtered)
+	}
+	if rejects.Len() != 0 || len(problems) != 0 {
+		t.Errorf("rejects = %q, problems = %v, want none", rejects.String(), problems)
+	}
+
+	// Strict mode leaves them out without aborting
+	opts.Strict, opts.Rejects, opts.OnProblem = true, nil, nil
+	if _, err := ProcessCSV(strings.NewReader(input), io.Discard, opts); err != nil {
+		t.Errorf("strict: %v", err)
+	}
+}
+
+func TestProfileDelimiter(t *testing.T) {
+	profile, ok := LookupProfile("amex-nl")
+	if !ok {
+		t.Fatal("amex-nl profile not registered")
+	}
+	want := "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n"
+
+	// The profile's comma gives way to the detected delimiter
+	var opts Options
+	profile.Apply(&opts)
+	for _, delimiter := range []string{";", "\t"} {
+		input := strings.Join([]string{"Datum", "Omschrijving", "Bedrag"}, delimiter) + "\n" +
+			strings.Join([]string{"03/15/2024", "ALBERT HEIJN", "12,34"}, delimiter) + "\n"
+		if got, _ := convertString(t, input, opts); got != want {
+			t.Errorf("delimiter %q: output = %q, want %q", delimiter, got, want)
+		}
+	}
+
+	// and is used when the header line has none of the usual ones
+	profile.Delimiter = '|'
+	profile.Apply(&opts)
+	if got, _ := convertString(t, "Datum|Omschrijving|Bedrag\n03/15/2024|ALBERT HEIJN|12,34\n", opts); got != want {
+		t.Errorf("delimiter '|': output = %q, want %q", got, want)
+	}
+}
+
+func TestCreateColumnMapperReturnsCopy(t *testing.T) {
+	mapper := CreateColumnMapper()
+	mapper.DateColumns[0] = "Changed"
+	if got := CreateColumnMapper().DateColumns[0]; got != "Datum" {
+		t.Errorf("DateColumns[0] = %q after changing a copy, want Datum", got)
+	}
+}
+
+func TestNewSampleConverter(t *testing.T) {
+	input := "Datum,Omschrijving,Bedrag\n03/15/2024,A,\"1,00\"\n03/16/2024,B,\"2,00\"\n03/17/2024,C,\"3,00\"\n"
+
+	// The sample ends after two transactions as valid JSON, the summary
+	// counts all three
+	var output bytes.Buffer
+	converter := NewSampleConverter(&output, 2, Options{OutputFormat: OutputJSON, Logger: testLogger{t}})
+	if err := converter.Convert(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	if err := converter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var transactions []map[string]any
+	if err := json.Unmarshal(output.Bytes(), &transactions); err != nil {
+		t.Fatalf("sample is not valid JSON: %v\n%s", err, output.String())
+	}
+	if len(transactions) != 2 {
+		t.Errorf("sample has %d transactions, want 2", len(transactions))
+	}
+	if got := converter.Summary().Transactions; got != 3 {
+		t.Errorf("Transactions = %d, want 3", got)
+	}
+}
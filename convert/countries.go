@@ -0,0 +1,77 @@
+package convert
+
+import "strings"
+
+// countryNames maps ISO 3166-1 alpha-2 codes to English country names, for
+// the countries card transactions most often come from
+var countryNames = map[string]string{
+	"AE": "United Arab Emirates",
+	"AR": "Argentina",
+	"AT": "Austria",
+	"AU": "Australia",
+	"BE": "Belgium",
+	"BG": "Bulgaria",
+	"BR": "Brazil",
+	"CA": "Canada",
+	"CH": "Switzerland",
+	"CL": "Chile",
+	"CN": "China",
+	"CO": "Colombia",
+	"CY": "Cyprus",
+	"CZ": "Czechia",
+	"DE": "Germany",
+	"DK": "Denmark",
+	"EE": "Estonia",
+	"EG": "Egypt",
+	"ES": "Spain",
+	"FI": "Finland",
+	"FR": "France",
+	"GB": "United Kingdom",
+	"GR": "Greece",
+	"HK": "Hong Kong",
+	"HR": "Croatia",
+	"HU": "Hungary",
+	"ID": "Indonesia",
+	"IE": "Ireland",
+	"IL": "Israel",
+	"IN": "India",
+	"IS": "Iceland",
+	"IT": "Italy",
+	"JP": "Japan",
+	"KR": "South Korea",
+	"LT": "Lithuania",
+	"LU": "Luxembourg",
+	"LV": "Latvia",
+	"MA": "Morocco",
+	"MT": "Malta",
+	"MX": "Mexico",
+	"MY": "Malaysia",
+	"NL": "Netherlands",
+	"NO": "Norway",
+	"NZ": "New Zealand",
+	"PH": "Philippines",
+	"PL": "Poland",
+	"PT": "Portugal",
+	"RO": "Romania",
+	"SA": "Saudi Arabia",
+	"SE": "Sweden",
+	"SG": "Singapore",
+	"SI": "Slovenia",
+	"SK": "Slovakia",
+	"TH": "Thailand",
+	"TR": "Turkey",
+	"TW": "Taiwan",
+	"US": "United States",
+	"VN": "Vietnam",
+	"ZA": "South Africa",
+}
+
+// expandCountry returns the English name of a two-letter country code,
+// ignoring case and surrounding whitespace, or country itself if the code
+// is unknown
+func expandCountry(country string) string {
+	if name, ok := countryNames[strings.ToUpper(strings.TrimSpace(country))]; ok {
+		return name
+	}
+	return country
+}
@@ -0,0 +1,41 @@
+package convert
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+)
+
+// Input encodings supported by NewDecoder
+const (
+	EncodingUTF8        = "utf-8"
+	EncodingWindows1252 = "windows-1252"
+	EncodingISO88591    = "iso-8859-1"
+)
+
+// Encodings lists the input encodings NewDecoder accepts
+var Encodings = []string{EncodingUTF8, EncodingWindows1252, EncodingISO88591}
+
+// charmaps maps each single-byte encoding to its decoder
+var charmaps = map[string]encoding.Encoding{
+	EncodingISO88591:    charmap.ISO8859_1,
+	EncodingWindows1252: charmap.Windows1252,
+}
+
+// NewDecoder returns a reader that decodes input from one of the Encodings
+// to UTF-8. UTF-8 input is returned unchanged.
+func NewDecoder(input io.Reader, encoding string) (io.Reader, error) {
+	encoding = strings.ToLower(encoding)
+	if encoding == EncodingUTF8 {
+		return input, nil
+	}
+	charmap, ok := charmaps[encoding]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoding %q, expected one of %s", encoding, strings.Join(Encodings, ", "))
+	}
+	return transform.NewReader(input, charmap.NewDecoder()), nil
+}
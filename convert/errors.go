@@ -0,0 +1,70 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEmptyInput is returned for an input without a header row
+var ErrEmptyInput = errors.New("input file is empty")
+
+// ErrMissingColumns matches any *MissingColumnsError with errors.Is
+var ErrMissingColumns = errors.New("missing required columns")
+
+// MissingColumnsError is returned when the header lacks a required column
+type MissingColumnsError struct {
+	// Fields names every missing field, such as "Date"
+	Fields []string
+	// Aliases holds the column names tried for each of the Fields
+	Aliases [][]string
+	// Header is the header row of the input
+	Header []string
+}
+
+func (e *MissingColumnsError) Error() string {
+	missing := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		missing[i] = fmt.Sprintf("%s (tried: %s)", field, strings.Join(e.Aliases[i], ", "))
+	}
+	return fmt.Sprintf("missing required columns: %s; header contains: %s", strings.Join(missing, "; "), strings.Join(e.Header, ", "))
+}
+
+// Is makes errors.Is(err, ErrMissingColumns) match
+func (e *MissingColumnsError) Is(target error) bool {
+	return target == ErrMissingColumns
+}
+
+// ParseError is returned in strict mode for a date or amount that couldn't
+// be parsed
+type ParseError struct {
+	// Line is the input line of the value
+	Line int
+	// Field is "date" or "amount"
+	Field string
+	// Value is the value as it appeared in the input
+	Value string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// CurrencyError is returned in strict mode without Options.Rejects for an
+// amount explicitly marked with another currency than Options.Currency
+type CurrencyError struct {
+	// Line is the input line of the amount
+	Line     int
+	Amount   string
+	Currency string
+	Expected string
+}
+
+func (e *CurrencyError) Error() string {
+	return fmt.Sprintf("line %d: amount %q is in %s, expected %s", e.Line, e.Amount, e.Currency, e.Expected)
+}
@@ -0,0 +1,53 @@
+package convert
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files with the current output, run it as
+// go test ./convert -run Golden -update
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenCases are the fixtures in testdata compared against the .golden
+// file of the same name
+var goldenCases = []struct {
+	name string
+	opts Options
+}{
+	// Dutch export with every optional column
+	{name: "dutch"},
+	// Amounts with thousands separators, currencies and trailing signs
+	{name: "european", opts: Options{Locale: LocaleNL}},
+	// English export with only the required columns
+	{name: "missing-optional"},
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			input, err := os.ReadFile(filepath.Join("testdata", tc.name+".csv"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := convertString(t, string(input), tc.opts)
+
+			golden := filepath.Join("testdata", tc.name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal([]byte(got), want) {
+				t.Errorf("output differs from %s, rerun with -update if the change is intended\ngot:\n%s\nwant:\n%s", golden, got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,499 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Output file formats supported by Options.OutputFormat
+const (
+	OutputCSV  = "csv"
+	OutputQIF  = "qif"
+	OutputJSON = "json"
+)
+
+// Amount formats supported by Options.AmountFormat
+const (
+	// AmountDecimal writes amounts with two decimals, e.g. -12.34
+	AmountDecimal = "decimal"
+	// AmountMilliunits writes amounts as integer thousandths, e.g. -12340,
+	// as the YNAB API expects them
+	AmountMilliunits = "milliunits"
+)
+
+// Sort orders supported by Options.Sort
+const (
+	// SortNone writes transactions in input order
+	SortNone = "none"
+	// SortAsc writes the oldest transaction first
+	SortAsc = "asc"
+	// SortDesc writes the newest transaction first
+	SortDesc = "desc"
+)
+
+// transaction is a converted row, ready to be written in any output format
+type transaction struct {
+	// Date is YYYY-MM-DD, or the original value if it couldn't be parsed
+	Date  string
+	Payee string
+	Memo  string
+	// Amount is YNAB signed, valid only when AmountErr is nil
+	Amount    float64
+	AmountErr error
+	// RawAmount is the amount as it appeared in the input
+	RawAmount string
+	// Account is the card or account of the row, empty when the input
+	// has no account column
+	Account string
+}
+
+// transactionWriter writes converted transactions in one output format
+type transactionWriter interface {
+	writeHeader() error
+	writeTransaction(t transaction) error
+	flush() error
+	// close writes anything the format needs after the last transaction and
+	// flushes the output
+	close() error
+}
+
+// newTransactionWriter returns the writer for opts.OutputFormat
+func newTransactionWriter(output io.Writer, opts Options) transactionWriter {
+	switch opts.OutputFormat {
+	case OutputQIF:
+		return &qifWriter{writer: bufio.NewWriter(output)}
+	case OutputJSON:
+		return &jsonWriter{writer: bufio.NewWriter(output)}
+	}
+	header := opts.Header
+	if len(header) == 0 {
+		header = DefaultHeader(opts.Format)
+	}
+	writer := csv.NewWriter(output)
+	writer.UseCRLF = opts.CRLF
+	w := &csvWriter{writer: writer, format: opts.Format, amountFormat: opts.AmountFormat, dateLayout: opts.DateLayout, header: header}
+	if opts.OutputBOM {
+		w.bom = output
+	}
+	return w
+}
+
+// DefaultHeader returns the YNAB CSV header for a Format
+func DefaultHeader(format string) []string {
+	if format == FormatInflowOutflow {
+		return []string{"Date", "Payee", "Memo", "Outflow", "Inflow"}
+	}
+	return []string{"Date", "Payee", "Memo", "Amount"}
+}
+
+// OptionalHeaderFields can be added to a custom CSV header on top of the
+// default header fields
+var OptionalHeaderFields = []string{"Account"}
+
+// ValidateHeader checks that a custom CSV header names every field of the
+// default header for format exactly once, in any order and ignoring case.
+// It may also name OptionalHeaderFields once.
+func ValidateHeader(header []string, format string) error {
+	required := DefaultHeader(format)
+	known := append(slices.Clone(required), OptionalHeaderFields...)
+	seen := map[string]bool{}
+	missing := len(required)
+	for _, name := range header {
+		field := strings.ToLower(strings.TrimSpace(name))
+		if !slices.ContainsFunc(known, func(r string) bool { return strings.ToLower(r) == field }) {
+			return fmt.Errorf("unknown header field %q, expected %s", name, strings.Join(known, ", "))
+		}
+		if seen[field] {
+			return fmt.Errorf("header field %q appears more than once", name)
+		}
+		seen[field] = true
+		if slices.ContainsFunc(required, func(r string) bool { return strings.ToLower(r) == field }) {
+			missing--
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("header must contain %s", strings.Join(required, ", "))
+	}
+	return nil
+}
+
+// csvWriter writes YNAB's CSV import format
+type csvWriter struct {
+	writer       *csv.Writer
+	format       string
+	amountFormat string
+	// dateLayout is the Go layout dates are written in, empty means DateLayout
+	dateLayout string
+	header     []string
+	// bom is the output to write a UTF-8 byte order mark to before the
+	// header, nil writes none
+	bom io.Writer
+}
+
+func (w *csvWriter) writeHeader() error {
+	if w.bom != nil {
+		if _, err := w.bom.Write(utf8BOM); err != nil {
+			return err
+		}
+	}
+	return w.writer.Write(w.header)
+}
+
+func (w *csvWriter) writeTransaction(t transaction) error {
+	fields := w.fields(t)
+	record := make([]string, len(w.header))
+	for i, name := range w.header {
+		record[i] = fields[strings.ToLower(strings.TrimSpace(name))]
+	}
+	return w.writer.Write(record)
+}
+
+// fields returns the values of a transaction keyed by lowercase header field
+func (w *csvWriter) fields(t transaction) map[string]string {
+	fields := map[string]string{"date": t.Date, "payee": t.Payee, "memo": t.Memo, "account": t.Account}
+
+	// Rewrite the date in the chosen layout, passing unparsed dates through
+	if w.dateLayout != "" {
+		if parsed, err := time.Parse(DateLayout, t.Date); err == nil {
+			fields["date"] = parsed.Format(w.dateLayout)
+		}
+	}
+
+	// Format the amount, either signed or split into outflow and inflow
+	switch {
+	case t.AmountErr != nil && w.format == FormatInflowOutflow:
+		// Keep the original in the outflow column if parsing fails
+		fields["outflow"] = t.RawAmount
+	case t.AmountErr != nil:
+		// Keep the original if parsing fails
+		fields["amount"] = t.RawAmount
+	case w.format == FormatInflowOutflow && t.Amount < 0:
+		fields["outflow"] = w.formatAmount(-t.Amount)
+	case w.format == FormatInflowOutflow:
+		fields["inflow"] = w.formatAmount(t.Amount)
+	default:
+		fields["amount"] = w.formatAmount(t.Amount)
+	}
+	return fields
+}
+
+// formatAmount formats an amount in the writer's amount format
+func (w *csvWriter) formatAmount(amount float64) string {
+	if w.amountFormat == AmountMilliunits {
+		return formatMilliunits(amount)
+	}
+	return formatYNABAmount(amount)
+}
+
+func (w *csvWriter) flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvWriter) close() error {
+	return w.flush()
+}
+
+// qifWriter writes Quicken Interchange Format records for a credit card account
+type qifWriter struct {
+	writer *bufio.Writer
+}
+
+// qifDateLayout is the MM/DD/YYYY layout QIF dates are written in
+const qifDateLayout = "01/02/2006"
+
+func (w *qifWriter) writeHeader() error {
+	_, err := w.writer.WriteString("!Type:CCard\n")
+	return err
+}
+
+func (w *qifWriter) writeTransaction(t transaction) error {
+	// Dates that couldn't be parsed are passed through as is
+	date := t.Date
+	if parsed, err := time.Parse(DateLayout, t.Date); err == nil {
+		date = parsed.Format(qifDateLayout)
+	}
+
+	// Keep the original amount if parsing fails
+	amount := t.RawAmount
+	if t.AmountErr == nil {
+		amount = formatYNABAmount(t.Amount)
+	}
+
+	fmt.Fprintf(w.writer, "D%s\nP%s\n", date, qifLine(t.Payee))
+	if t.Memo != "" {
+		fmt.Fprintf(w.writer, "M%s\n", qifLine(t.Memo))
+	}
+	_, err := fmt.Fprintf(w.writer, "T%s\n^\n", amount)
+	return err
+}
+
+func (w *qifWriter) flush() error {
+	return w.writer.Flush()
+}
+
+func (w *qifWriter) close() error {
+	return w.flush()
+}
+
+// qifLine keeps a field on a single line, since QIF is line oriented
+func qifLine(field string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(field)
+}
+
+// jsonWriter streams a JSON array of transactions in the shape of the YNAB
+// API's SaveTransaction, with amounts in milliunits
+type jsonWriter struct {
+	writer *bufio.Writer
+	// started is set once the opening bracket is written
+	started bool
+	count   int
+	// occurrences counts the transactions written per import ID prefix
+	occurrences map[string]int
+}
+
+// importIDLimit is the longest import_id the YNAB API accepts
+const importIDLimit = 36
+
+// jsonTransaction is a transaction as the YNAB API expects it
+type jsonTransaction struct {
+	Date      string `json:"date"`
+	PayeeName string `json:"payee_name"`
+	Memo      string `json:"memo"`
+	Amount    int64  `json:"amount"`
+	// ImportID identifies the transaction to the API, so importing the same
+	// export twice doesn't duplicate it
+	ImportID string `json:"import_id,omitempty"`
+}
+
+// importID returns a deterministic import_id such as
+// "AMEX:2024-03-15:-12340:2" for the second transaction with that date and
+// amount, or "" if it would be longer than the API accepts
+func (w *jsonWriter) importID(date string, milliunits int64) string {
+	if w.occurrences == nil {
+		w.occurrences = map[string]int{}
+	}
+	prefix := fmt.Sprintf("AMEX:%s:%d", date, milliunits)
+	w.occurrences[prefix]++
+	id := fmt.Sprintf("%s:%d", prefix, w.occurrences[prefix])
+	if len(id) > importIDLimit {
+		return ""
+	}
+	return id
+}
+
+func (w *jsonWriter) writeHeader() error {
+	w.started = true
+	_, err := w.writer.WriteString("[")
+	return err
+}
+
+func (w *jsonWriter) writeTransaction(t transaction) error {
+	// The API has no way to pass an amount through unparsed
+	if t.AmountErr != nil {
+		return fmt.Errorf("amount %q can't be written as JSON: %w", t.RawAmount, t.AmountErr)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	amount := int64(math.Round(t.Amount * 1000))
+	if err := encoder.Encode(jsonTransaction{
+		Date:      t.Date,
+		PayeeName: t.Payee,
+		Memo:      t.Memo,
+		Amount:    amount,
+		ImportID:  w.importID(t.Date, amount),
+	}); err != nil {
+		return err
+	}
+
+	// Separate the objects and keep one per line
+	if w.count > 0 {
+		w.writer.WriteString(",")
+	}
+	w.writer.WriteString("\n")
+	w.count++
+	_, err := w.writer.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+	return err
+}
+
+func (w *jsonWriter) flush() error {
+	return w.writer.Flush()
+}
+
+func (w *jsonWriter) close() error {
+	if !w.started {
+		w.writer.WriteString("[")
+	}
+	if _, err := w.writer.WriteString("\n]\n"); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// sortWriter holds every transaction until close and then writes them to out
+// ordered by date. Transactions whose date couldn't be parsed go last, in
+// input order.
+type sortWriter struct {
+	out          transactionWriter
+	descending   bool
+	transactions []transaction
+}
+
+// newSortWriter wraps out in a sortWriter for opts.Sort, or returns out as
+// is when no order is asked for
+func newSortWriter(out transactionWriter, opts Options) transactionWriter {
+	if opts.Sort != SortAsc && opts.Sort != SortDesc {
+		return out
+	}
+	return &sortWriter{out: out, descending: opts.Sort == SortDesc}
+}
+
+func (w *sortWriter) writeHeader() error {
+	return w.out.writeHeader()
+}
+
+func (w *sortWriter) writeTransaction(t transaction) error {
+	w.transactions = append(w.transactions, t)
+	return nil
+}
+
+// flush only flushes what out has written, the transactions wait for close
+func (w *sortWriter) flush() error {
+	return w.out.flush()
+}
+
+func (w *sortWriter) close() error {
+	dates := make(map[string]time.Time, len(w.transactions))
+	for _, t := range w.transactions {
+		if parsed, err := time.Parse(DateLayout, t.Date); err == nil {
+			dates[t.Date] = parsed
+		}
+	}
+	slices.SortStableFunc(w.transactions, func(a, b transaction) int {
+		dateA, okA := dates[a.Date]
+		dateB, okB := dates[b.Date]
+		switch {
+		case !okA || !okB:
+			// Unparsed dates after parsed ones, keeping their own order
+			return boolCompare(!okA, !okB)
+		case w.descending:
+			return dateB.Compare(dateA)
+		}
+		return dateA.Compare(dateB)
+	})
+
+	for _, t := range w.transactions {
+		if err := w.out.writeTransaction(t); err != nil {
+			return err
+		}
+	}
+	w.transactions = nil
+	return w.out.close()
+}
+
+// boolCompare orders false before true
+func boolCompare(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	}
+	return -1
+}
+
+// UnknownMonth is the month key of transactions whose date couldn't be parsed
+const UnknownMonth = "unknown"
+
+// headWriter passes the first n transactions on to out and drops the rest
+type headWriter struct {
+	out transactionWriter
+	n   int
+}
+
+func (w *headWriter) writeHeader() error {
+	return w.out.writeHeader()
+}
+
+func (w *headWriter) writeTransaction(t transaction) error {
+	if w.n == 0 {
+		return nil
+	}
+	w.n--
+	return w.out.writeTransaction(t)
+}
+
+func (w *headWriter) flush() error {
+	return w.out.flush()
+}
+
+func (w *headWriter) close() error {
+	return w.out.close()
+}
+
+// monthWriter splits transactions by calendar month, opening one output per
+// month the first time a transaction for it is written
+type monthWriter struct {
+	open    func(month string) (io.Writer, error)
+	opts    Options
+	header  bool
+	writers map[string]transactionWriter
+}
+
+// writeHeader only records that a header is wanted, each output gets its own
+// when it is opened
+func (w *monthWriter) writeHeader() error {
+	w.header = true
+	return nil
+}
+
+func (w *monthWriter) writeTransaction(t transaction) error {
+	month := UnknownMonth
+	if parsed, err := time.Parse(DateLayout, t.Date); err == nil {
+		month = parsed.Format("2006-01")
+	}
+
+	writer, ok := w.writers[month]
+	if !ok {
+		output, err := w.open(month)
+		if err != nil {
+			return err
+		}
+		writer = newTransactionWriter(output, w.opts)
+		if w.header {
+			if err := writer.writeHeader(); err != nil {
+				return err
+			}
+		}
+		w.writers[month] = writer
+	}
+	return writer.writeTransaction(t)
+}
+
+func (w *monthWriter) flush() error {
+	for _, writer := range w.writers {
+		if err := writer.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *monthWriter) close() error {
+	for _, writer := range w.writers {
+		if err := writer.close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
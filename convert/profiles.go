@@ -0,0 +1,130 @@
+package convert
+
+import (
+	"slices"
+	"strings"
+)
+
+// Profile bundles the settings of one bank's export format
+type Profile struct {
+	Name   string
+	Mapper ColumnMapper
+	// Delimiter is the input field separator when none is forced and the
+	// header line doesn't give it away, zero means comma
+	Delimiter rune
+	// Locale is the decimal separator of amounts, see Options.Locale
+	Locale string
+	// DateLayout is the Go time layout of input dates, tried before the
+	// built-in ones
+	DateLayout string
+}
+
+// Apply copies the profile's settings into opts
+func (p Profile) Apply(opts *Options) {
+	mapper := p.Mapper.clone()
+	opts.Mapper = &mapper
+	opts.DefaultDelimiter = p.Delimiter
+	opts.Locale = p.Locale
+	opts.InputDateLayouts = nil
+	if p.DateLayout != "" {
+		opts.InputDateLayouts = []string{p.DateLayout}
+	}
+}
+
+// DefaultProfile is the name of the built-in profile used without a
+// Mapper, with the Dutch and English Amex column names
+const DefaultProfile = "default"
+
+// profiles holds the registered profiles by lowercase name
+var profiles = map[string]Profile{}
+
+// RegisterProfile adds a profile, replacing any profile with the same name
+func RegisterProfile(p Profile) {
+	profiles[strings.ToLower(p.Name)] = p
+}
+
+// LookupProfile returns the profile registered under name, ignoring case
+func LookupProfile(name string) (Profile, bool) {
+	p, ok := profiles[strings.ToLower(name)]
+	return p, ok
+}
+
+// ProfileNames returns the names of all registered profiles, sorted
+func ProfileNames() []string {
+	names := make([]string, 0, len(profiles))
+	for _, p := range profiles {
+		names = append(names, p.Name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func init() {
+	RegisterProfile(Profile{
+		Name: DefaultProfile,
+		Mapper: ColumnMapper{
+			DateColumns:            []string{"Datum", "Date"},
+			PayeeColumns:           []string{"Verschijnt op uw rekeningoverzicht als", "Omschrijving", "Description"},
+			AmountColumns:          []string{"Bedrag", "Amount"},
+			MemoColumns:            []string{"Aanvullende informatie", "Additional Information"},
+			ReferenceColumns:       []string{"Referentie", "Reference"},
+			LocationColumns:        []string{"Plaats", "City"},
+			PostcodeColumns:        []string{"Postcode", "Postcode/Zip"},
+			CountryColumns:         []string{"Land", "Country"},
+			StatusColumns:          []string{"Status"},
+			CategoryColumns:        []string{"Categorie", "Category"},
+			ForeignAmountColumns:   []string{"Bedrag in vreemde valuta", "Foreign Amount", "Foreign Spend Amount"},
+			ForeignCurrencyColumns: []string{"Vreemde valuta", "Foreign Currency"},
+			IndicatorColumns:       []string{"Af Bij", "Af/Bij", "Debit/Credit", "D/C"},
+			DebitColumns:           []string{"Af", "Debet", "Debit"},
+			CreditColumns:          []string{"Bij", "Credit"},
+			AccountColumns:         []string{"Rekening", "Rekeningnummer", "Account", "Account Number"},
+			CardMemberColumns:      []string{"Kaarthouder", "Kaartlid", "Card Member"},
+		},
+	})
+	RegisterProfile(Profile{
+		Name:       "amex-nl",
+		Mapper:     CreateColumnMapper(),
+		Delimiter:  ',',
+		Locale:     LocaleNL,
+		DateLayout: "01/02/2006",
+	})
+	RegisterProfile(Profile{
+		Name:       "amex-us",
+		Mapper:     englishColumnMapper("City/State", "Zip Code"),
+		Delimiter:  ',',
+		Locale:     LocaleEN,
+		DateLayout: "01/02/2006",
+	})
+	RegisterProfile(Profile{
+		Name:       "amex-uk",
+		Mapper:     englishColumnMapper("Town/City", "Postcode"),
+		Delimiter:  ',',
+		Locale:     LocaleEN,
+		DateLayout: "02/01/2006",
+	})
+}
+
+// englishColumnMapper returns the column names of English Amex exports,
+// which differ per region only in the city and postcode columns
+func englishColumnMapper(city string, postcode string) ColumnMapper {
+	return ColumnMapper{
+		DateColumns:            []string{"Date"},
+		PayeeColumns:           []string{"Appears On Your Statement As", "Description"},
+		AmountColumns:          []string{"Amount"},
+		MemoColumns:            []string{"Extended Details", "Additional Information"},
+		ReferenceColumns:       []string{"Reference"},
+		LocationColumns:        []string{city, "City"},
+		PostcodeColumns:        []string{postcode},
+		CountryColumns:         []string{"Country"},
+		StatusColumns:          []string{"Status"},
+		CategoryColumns:        []string{"Category"},
+		ForeignAmountColumns:   []string{"Foreign Spend Amount", "Foreign Amount"},
+		ForeignCurrencyColumns: []string{"Foreign Currency"},
+		IndicatorColumns:       []string{"Debit/Credit", "D/C"},
+		DebitColumns:           []string{"Debit"},
+		CreditColumns:          []string{"Credit"},
+		AccountColumns:         []string{"Account", "Account Number"},
+		CardMemberColumns:      []string{"Card Member"},
+	}
+}
@@ -0,0 +1,109 @@
+package convert
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// PayeeRule rewrites payees matching Pattern to Replacement, which may refer
+// to capture groups like $1
+type PayeeRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// LoadPayeeRules reads payee rules from path, one "pattern<TAB>replacement"
+// pair per line. Blank lines and lines starting with # are ignored.
+func LoadPayeeRules(path string) ([]PayeeRule, error) {
+	var rules []PayeeRule
+	err := readPatternFile(path, "replacement", func(pattern *regexp.Regexp, replacement string) error {
+		rules = append(rules, PayeeRule{Pattern: pattern, Replacement: replacement})
+		return nil
+	})
+	return rules, err
+}
+
+// SignRule forces the YNAB sign of transactions whose payee, as it appears
+// in the input, matches Pattern
+type SignRule struct {
+	Pattern *regexp.Regexp
+	// Outflow makes the amount negative, otherwise it is made positive
+	Outflow bool
+}
+
+// LoadSignRules reads sign rules from path, one "pattern<TAB>sign" pair per
+// line, where sign is "-" or "outflow", or "+" or "inflow". Blank lines and
+// lines starting with # are ignored.
+func LoadSignRules(path string) ([]SignRule, error) {
+	var rules []SignRule
+	err := readPatternFile(path, "sign", func(pattern *regexp.Regexp, sign string) error {
+		switch strings.ToLower(strings.TrimSpace(sign)) {
+		case "-", "outflow":
+			rules = append(rules, SignRule{Pattern: pattern, Outflow: true})
+		case "+", "inflow":
+			rules = append(rules, SignRule{Pattern: pattern})
+		default:
+			return fmt.Errorf("unknown sign %q, expected -, +, outflow or inflow", sign)
+		}
+		return nil
+	})
+	return rules, err
+}
+
+// readPatternFile calls add with the compiled pattern and the value of every
+// "pattern<TAB>value" line of path, skipping blank lines and # comments.
+// Errors name the file and line.
+func readPatternFile(path string, valueName string, add func(pattern *regexp.Regexp, value string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		pattern, value, found := strings.Cut(line, "\t")
+		if !found {
+			return fmt.Errorf("%s:%d: expected pattern and %s separated by a tab", path, lineNumber, valueName)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+		if err := add(re, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// applyPayeeRules runs payee through every rule in order
+func applyPayeeRules(payee string, rules []PayeeRule) string {
+	for _, rule := range rules {
+		payee = rule.Pattern.ReplaceAllString(payee, rule.Replacement)
+	}
+	return payee
+}
+
+// applySignRules gives amount the sign of the first rule matching payee, or
+// returns it unchanged
+func applySignRules(amount float64, payee string, rules []SignRule) float64 {
+	for _, rule := range rules {
+		if rule.Pattern.MatchString(payee) {
+			if rule.Outflow {
+				return -math.Abs(amount)
+			}
+			return math.Abs(amount)
+		}
+	}
+	return amount
+}
@@ -0,0 +1,5 @@
+module github.com/alexanderjeurissen/amex2ynab
+
+go 1.21
+
+require golang.org/x/text v0.22.0
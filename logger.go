@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// logLevel controls which messages the CLI prints
+type logLevel int
+
+const (
+	// levelError only prints errors, set by -quiet
+	levelError logLevel = iota
+	// levelInfo also prints warnings, totals and the result, the default
+	levelInfo
+	// levelDebug also traces every row, set by -verbose
+	levelDebug
+)
+
+// leveledLogger prints messages up to its level. Informational messages go to
+// info, everything else to diag so piped output stays clean.
+type leveledLogger struct {
+	level logLevel
+	info  io.Writer
+	diag  io.Writer
+}
+
+// newLogger returns a logger for the -quiet and -verbose flags
+func newLogger(quiet bool, verbose bool) *leveledLogger {
+	level := levelInfo
+	if quiet {
+		level = levelError
+	} else if verbose {
+		level = levelDebug
+	}
+	return &leveledLogger{level: level, info: os.Stdout, diag: os.Stderr}
+}
+
+// Fatalf prints an error regardless of level and exits
+func (l *leveledLogger) Fatalf(format string, args ...any) {
+	fmt.Fprintf(l.diag, format+"\n", args...)
+	os.Exit(1)
+}
+
+// Warnf prints a warning unless quiet
+func (l *leveledLogger) Warnf(format string, args ...any) {
+	if l.level >= levelInfo {
+		fmt.Fprintf(l.diag, "Warning: "+format+"\n", args...)
+	}
+}
+
+// Infof prints an informational message unless quiet
+func (l *leveledLogger) Infof(format string, args ...any) {
+	if l.level >= levelInfo {
+		fmt.Fprintf(l.info, format+"\n", args...)
+	}
+}
+
+// Debugf prints a trace message when verbose
+func (l *leveledLogger) Debugf(format string, args ...any) {
+	if l.level >= levelDebug {
+		fmt.Fprintf(l.diag, format+"\n", args...)
+	}
+}
@@ -1,249 +1,1213 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"encoding/csv"
 	"flag"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/alexanderjeurissen/amex2ynab/convert"
 )
 
-// ColumnMapper helps map source columns to target columns
-type ColumnMapper struct {
-	DateColumns      []string
-	PayeeColumns     []string
-	AmountColumns    []string
-	MemoColumns      []string
-	ReferenceColumns []string
-	LocationColumns  []string
-	PostcodeColumns  []string
-	CountryColumns   []string
-}
+// exitParseErrors is the exit code when the output was written but some
+// dates or amounts couldn't be parsed
+const exitParseErrors = 2
+
+// version is stamped by release builds with
+// -ldflags "-X main.version=<version>"
+var version = "dev"
 
 func main() {
 	// Define flags
-	inputFilePath := flag.String("input", "", "Path to input CSV file (required)")
-	// Get default output path with timestamp in the format ~/Desktop/ynab_amex_export_YYYYMMDDHHmmss.csv
-	homeDir, _ := os.UserHomeDir()
-	defaultOutputPath := filepath.Join(homeDir, "Desktop", fmt.Sprintf("ynab_amex_export_%s.csv", time.Now().Format("20060102150405")))
-	outputFilePath := flag.String("output", defaultOutputPath, "Path to output CSV file")
+	var inputFilePaths stringList
+	flag.Var(&inputFilePaths, "input", "Path to input CSV file, - for stdin, or a zip archive of CSV files (required unless data is piped in, repeat or separate with commas to merge several files)")
+	// Get default output path with timestamp in the format ~/Desktop/ynab_amex_export_YYYYMMDDHHmmss.csv,
+	// in the working directory when there is no Desktop
+	defaultOutputName := fmt.Sprintf("ynab_amex_export_%s.csv", time.Now().Format("20060102150405"))
+	outputFilePath := flag.String("output", filepath.Join(defaultOutputDir(), defaultOutputName), "Path to output file, - for stdout")
+	nameByDates := flag.Bool("name-by-dates", false, "Name the default output file after the first and last transaction dates, e.g. ynab_amex_2024-03-01_2024-03-31.csv, keeping the timestamp when no date parses (ignored with -output)")
+	outDir := flag.String("out-dir", "", "Directory for the default timestamped output file instead of ~/Desktop (ignored with -output)")
+	profileName := flag.String("profile", "", "Bank profile setting the column names, delimiter, locale and date format: "+strings.Join(convert.ProfileNames(), ", ")+" (other flags override it)")
+	mappingFilePath := flag.String("mapping", "", "Path to JSON file with column names, overriding the AMEX2YNAB_*_COLUMNS environment variables (defaults to the built-in Amex mapping)")
+	fuzzyColumns := flag.Bool("fuzzy-columns", false, "Match columns whose name contains a known column name when there's no exact match")
+	signRulesFilePath := flag.String("sign-rules", "", "Path to file forcing the sign of matching payees, one \"regex<TAB>sign\" per line with sign - (outflow) or + (inflow), first match wins")
+	rulesFilePath := flag.String("rules", "", "Path to file with payee rewrite rules, one \"regex<TAB>replacement\" per line applied in order")
+	var columnOverrides stringList
+	flag.Var(&columnOverrides, "col-override", "Use the column at a 0-based index for a field, as field=index, e.g. amount=3 (repeat or separate with commas; see -list-columns)")
+	delimiter := flag.String("delimiter", "", "Input field separator, a single character or \"tab\" (auto-detected from the header when empty, tab for .tsv files)")
+	encoding := flag.String("encoding", convert.EncodingUTF8, "Character encoding of the input files: "+strings.Join(convert.Encodings, ", ")+" (output is always UTF-8)")
+	skipRows := flag.Int("skip-rows", 0, "Number of preamble lines to discard before the header row")
+	jobs := flag.Int("jobs", 1, "Number of input files to convert concurrently; the output keeps the input order (not with -dedup, -limit, -split-by-month or json output)")
+	limit := flag.Int("limit", 0, "Stop after writing this many transactions (0 or negative means no limit)")
+	autoHeader := flag.Bool("auto-header", false, "Find the header among the first 20 lines by its column names and discard the lines before it, for preambles of varying length")
+	dateFormats := flag.String("date-formats", "", "Comma-separated Go time layouts tried on input dates before the built-in ones, e.g. Jan-02-2006")
+	fromDate := flag.String("from", "", "Only include transactions on or after this date (YYYY-MM-DD)")
+	toDate := flag.String("to", "", "Only include transactions on or before this date (YYYY-MM-DD)")
+	strict := flag.Bool("strict", false, "Abort on the first date or amount that can't be parsed instead of passing it through (with -rejects, leave such rows out instead)")
+	rejectsFilePath := flag.String("rejects", "", "Path to a CSV file collecting the original input rows whose date or amount couldn't be parsed or was in another currency")
+	listColumns := flag.Bool("list-columns", false, "Print the columns of each input and the YNAB field each maps to, then exit without writing an output file")
+	interactive := flag.Bool("interactive", false, "Ask which column holds the date, payee or amount when it can't be found, if run from a terminal")
+	validate := flag.Bool("validate", false, "Check the inputs for missing columns, rows with the wrong number of fields and unparseable dates and amounts, then exit without writing an output file (exit code 1 for missing required columns, 2 for problem rows)")
+	dryRun := flag.Bool("dry-run", false, "Print detected columns, totals and sample rows without writing an output file")
+	currency := flag.String("currency", "", "Expected three-letter currency code of the amounts, e.g. EUR (rows marked with another currency are left out and written to -rejects)")
+	invert := flag.Bool("invert", true, "Invert amounts so Amex charges become YNAB outflows; use -invert=false for exports that already sign charges negative (with -format inflow-outflow the resulting sign picks the column)")
+	debitValues := flag.String("debit-values", strings.Join(convert.DefaultDebitIndicators, ","), "Comma-separated debit/credit indicator column values that mark a debit; rows with any other indicator are credits, regardless of -invert")
+	dedup := flag.Bool("dedup", false, "Skip rows identical to a row already converted in this run")
+	dedupKey := flag.String("dedup-key", "date,payee,amount,memo", "Comma-separated fields identifying a duplicate for -dedup: "+strings.Join(convert.DedupFields, ", "))
+	skipPending := flag.Bool("skip-pending", false, "Drop transactions whose status column is \"pending\"")
+	dropPayments := flag.Bool("drop-payments", false, "Skip payments made to the card, matched on the payee with -payment-pattern")
+	keepEmptyAmount := flag.Bool("keep-empty-amount", false, "Keep rows without an amount, such as informational entries, with the amount left blank (YNAB rejects blank amounts)")
+	dropZero := flag.Bool("drop-zero", false, "Skip transactions whose amount is zero, such as authorization holds")
+	paymentPattern := flag.String("payment-pattern", convert.DefaultPaymentPattern, "Regular expression matching payment payees for -drop-payments")
+	maxPayeeLen := flag.Int("max-payee-len", 200, "Truncate payees to this many characters, as YNAB cuts them off at 200 (0 means no limit)")
+	payeeOverflowMemo := flag.Bool("payee-overflow-memo", false, "Move the part of a payee cut off by -max-payee-len to the start of the memo")
+	include := flag.String("include", "", "Regular expression a payee must match to be converted, e.g. \"(?i)albert heijn|jumbo\"")
+	exclude := flag.String("exclude", "", "Regular expression of payees to leave out, winning over -include")
+	keepRawAmount := flag.Bool("keep-raw-amount", false, "Add the original Amex amount to the memo as \"Orig: <amount>\"")
+	payeeJoin := flag.String("payee-join", "", "Join every column matching a payee name with this separator, e.g. \" - \" for merchant and city, instead of using only the first")
+	noMemo := flag.Bool("no-memo", false, "Leave the memo empty, keeping the Memo column in the header")
+	memoRefDate := flag.Bool("memo-include-ref-date", false, "Write the reference as a sortable \"ID: 20240315-REF123\" with the transaction date instead of \"Ref: REF123\"")
+	payeeFromMemo := flag.Bool("payee-from-memo", false, "Use the memo column (e.g. Aanvullende informatie) as the payee and move the payee column into the memo, for exports whose payee is a code")
+	locationLabeled := flag.Bool("location-labeled", false, "Write the city, postcode and country as separately labeled memo parts, e.g. \"City: Amsterdam | Country: NL\", instead of one \"Location: Amsterdam, NL\"")
+	expandCountries := flag.Bool("expand-countries", false, "Write two-letter country codes in the memo location as country names, e.g. NL as Netherlands")
+	var extraCols stringList
+	flag.Var(&extraCols, "extra-cols", "Input column names added to the memo as \"Name: value\", e.g. \"Flight number\" (repeat or separate with commas; missing columns are skipped)")
+	tag := flag.String("tag", "", "Text added to the end of every memo, e.g. \"Card: Gold\", to tell imports apart")
+	noTrim := flag.Bool("no-trim", false, "Keep the payee and memo fields as they are instead of trimming them and collapsing runs of whitespace")
+	memoFields := flag.String("memo-fields", strings.Join(convert.DefaultMemoFields, ","), "Comma-separated memo parts in the order they're written: "+strings.Join(convert.DefaultMemoFields, ", ")+" (-extra-cols, -keep-raw-amount and -tag are added after them)")
+	memoSep := flag.String("memo-sep", convert.DefaultMemoSeparator, "Separator between memo parts; \\n is a newline")
+	sortOrder := flag.String("sort", convert.SortNone, "Order of the written transactions by date: asc, desc or none to keep input order. Sorting holds every transaction in memory until the end")
+	rounding := flag.String("rounding", convert.RoundHalfEven, "Rounding of amounts with more than two decimals: half-even, half-up or truncate")
+	locale := flag.String("locale", "", "Decimal separator of input amounts: en (1,234.56), nl (1.234,56) or auto to detect it per file from its amounts; guessed per amount when empty")
+	outputBOM := flag.Bool("output-bom", false, "Start CSV output with a UTF-8 byte order mark so Excel shows accented characters correctly (YNAB doesn't need it)")
+	crlf := flag.Bool("crlf", false, "End CSV output lines with CRLF (\\r\\n) as Windows tools expect, instead of LF")
+	outputFormat := flag.String("output-format", convert.OutputCSV, "Output file format: csv, qif or json (an array of YNAB API transactions with amounts in milliunits and import IDs)")
+	outDateFormat := flag.String("out-date-format", "iso", "CSV date format: iso (2006-01-02), us (01/02/2006), eu (02/01/2006) or a Go time layout")
+	amountFormat := flag.String("amount-format", convert.AmountDecimal, "CSV amount format: decimal (-12.34) or milliunits (-12340, as used by the YNAB API)")
+	header := flag.String("header", "", "Comma-separated CSV header in the desired column order, e.g. Date,Amount,Payee,Memo, optionally with Account (defaults to the fixed YNAB header)")
+	force := flag.Bool("force", false, "Overwrite an existing output file without asking")
+	appendOutput := flag.Bool("append", false, "Append to the output file instead of overwriting it, leaving out the header if the file isn't empty (-dedup only sees rows from this run, not those already in the file)")
+	splitByMonth := flag.Bool("split-by-month", false, "Write one file per calendar month, named like ynab_amex_2024-03.csv, to the directory of -output (or -output itself if it is a directory)")
+	format := flag.String("format", convert.FormatAmount, "Output format: amount (signed Amount column) or inflow-outflow (separate Outflow and Inflow columns)")
 
-	// Parse flags
+	quiet := flag.Bool("quiet", false, "Only print errors")
+	verbose := flag.Bool("verbose", false, "Trace column matches and every row's raw and converted amount")
+	showVersion := flag.Bool("version", false, "Print version and build information and exit")
+	configPath := flag.String("config", "", "TOML file setting defaults for any of these flags, keyed by flag name such as locale = \"nl\"; flags on the command line override it")
+
+	// Parse flags, then fill in the ones not given from the config file
 	flag.Parse()
+	if *configPath != "" {
+		if err := applyConfig(*configPath); err != nil {
+			usageError("reading config: %v", err)
+		}
+	}
+
+	logger := newLogger(*quiet, *verbose)
+
+	// Print the version before any other checks so it works without -input
+	if *showVersion {
+		fmt.Println(versionString())
+		return
+	}
+
+	// Read from stdin when data is piped in without an input file path
+	if len(inputFilePaths) == 0 && stdinIsPiped() {
+		inputFilePaths = stringList{stdinPath}
+	}
 
 	// Check if input file path is provided
-	if *inputFilePath == "" {
-		fmt.Println("Error: input file path is required")
-		flag.Usage()
-		os.Exit(1)
+	if len(inputFilePaths) == 0 {
+		usageError("input file path is required")
 	}
 
-	// Read the input file
-	inputFile, err := os.Open(*inputFilePath)
-	if err != nil {
-		log.Fatalf("Failed to open input file: %v", err)
+	// Check if the output format is supported
+	if *format != convert.FormatAmount && *format != convert.FormatInflowOutflow {
+		usageError("unsupported format %q", *format)
 	}
-	defer inputFile.Close()
 
-	// Create the output file
-	outputFile, err := os.Create(*outputFilePath)
-	if err != nil {
-		log.Fatalf("Failed to create output file: %v", err)
+	// Check if the output file format is supported
+	if *outputFormat != convert.OutputCSV && *outputFormat != convert.OutputQIF && *outputFormat != convert.OutputJSON {
+		usageError("unsupported output format %q", *outputFormat)
 	}
-	defer outputFile.Close()
 
-	// Process the CSV
-	if err := processCSV(inputFile, outputFile); err != nil {
-		log.Fatalf("Failed to process CSV: %v", err)
+	// Check if the amount format is supported, QIF only has decimal amounts
+	if *amountFormat != convert.AmountDecimal && *amountFormat != convert.AmountMilliunits {
+		usageError("unsupported amount format %q", *amountFormat)
+	}
+	if *amountFormat != convert.AmountDecimal && *outputFormat == convert.OutputQIF {
+		usageError("amount format %q is not supported with QIF output", *amountFormat)
+	}
+	if *keepEmptyAmount && *outputFormat == convert.OutputJSON {
+		usageError("-keep-empty-amount can't be combined with json output, the YNAB API needs an amount")
 	}
 
-	fmt.Printf("Successfully converted %s to YNAB format. Output saved to %s\n", *inputFilePath, *outputFilePath)
-}
+	// Put the default output file in the chosen directory
+	if *outDir != "" && !flagWasSet("output") {
+		if info, err := os.Stat(*outDir); err != nil || !info.IsDir() {
+			usageError("output directory %s does not exist", *outDir)
+		}
+		*outputFilePath = filepath.Join(*outDir, defaultOutputName)
+	}
 
-func processCSV(inputFile io.Reader, outputFile io.Writer) error {
-	// Create CSV readers and writers
-	reader := csv.NewReader(inputFile)
-	writer := csv.NewWriter(outputFile)
-	defer writer.Flush()
+	// The dates are only known after converting, so the file is renamed
+	// rather than appended to
+	renameByDates := *nameByDates && !flagWasSet("output") && !*splitByMonth
+	if renameByDates && *appendOutput {
+		usageError("-name-by-dates can't be combined with -append")
+	}
 
-	// Read the header
-	header, err := reader.Read()
-	if err != nil {
-		return fmt.Errorf("failed to read header: %w", err)
+	// Match the default output file extension to the output format
+	if *outputFormat != convert.OutputCSV && !flagWasSet("output") {
+		*outputFilePath = strings.TrimSuffix(*outputFilePath, filepath.Ext(*outputFilePath)) + "." + *outputFormat
 	}
 
-	// Create a column mapper
-	mapper := createColumnMapper()
+	// Check if the monthly files have a directory to go to
+	if *splitByMonth && *outputFilePath == stdoutPath {
+		usageError("-split-by-month can't write to stdout")
+	}
 
-	// Find index of each required column
-	dateIdx := findColumnIndex(header, mapper.DateColumns)
-	payeeIdx := findColumnIndex(header, mapper.PayeeColumns)
-	amountIdx := findColumnIndex(header, mapper.AmountColumns)
-	memoIdx := findColumnIndex(header, mapper.MemoColumns)
-	referenceIdx := findColumnIndex(header, mapper.ReferenceColumns)
-	locationIdx := findColumnIndex(header, mapper.LocationColumns)
-	postcodeIdx := findColumnIndex(header, mapper.PostcodeColumns)
-	countryIdx := findColumnIndex(header, mapper.CountryColumns)
+	// Check if the output can be appended to
+	if *appendOutput && (*outputFilePath == stdoutPath || *splitByMonth || *outputFormat == convert.OutputJSON) {
+		usageError("-append needs a CSV or QIF output file and can't be combined with -split-by-month")
+	}
 
-	// Check if required columns were found
-	if dateIdx == -1 || payeeIdx == -1 || amountIdx == -1 {
-		return fmt.Errorf("required columns not found in the CSV file")
+	// Check if the inputs can be converted concurrently, which needs every
+	// input to be independent of the others
+	if *jobs < 1 {
+		usageError("jobs must be at least 1, got %d", *jobs)
+	}
+	if *jobs > 1 && (*dedup || *limit > 0 || *splitByMonth || *outputFormat == convert.OutputJSON || *interactive || *sortOrder != convert.SortNone) {
+		usageError("-jobs can't be combined with -dedup, -limit, -split-by-month, -interactive, -sort or json output")
 	}
 
-	// Write YNAB header
-	err = writer.Write([]string{"Date", "Payee", "Memo", "Amount"})
-	if err != nil {
-		return fmt.Errorf("failed to write header: %w", err)
+	// Collect the conversion options
+	opts := convert.Options{
+		Format:              *format,
+		OutputFormat:        *outputFormat,
+		AmountFormat:        *amountFormat,
+		Strict:              *strict,
+		KeepSign:            !*invert,
+		SkipPending:         *skipPending,
+		DropZero:            *dropZero,
+		KeepEmptyAmount:     *keepEmptyAmount,
+		KeepRawAmount:       *keepRawAmount,
+		KeepWhitespace:      *noTrim,
+		Limit:               *limit,
+		ExpandCountries:     *expandCountries,
+		LocationLabeled:     *locationLabeled,
+		ExtraColumns:        extraCols,
+		Tag:                 *tag,
+		PayeeJoin:           *payeeJoin,
+		OutputBOM:           *outputBOM,
+		CRLF:                *crlf,
+		MaxPayeeLength:      *maxPayeeLen,
+		PayeeOverflowToMemo: *payeeOverflowMemo,
+		PayeeFromMemo:       *payeeFromMemo,
+		MemoRefDate:         *memoRefDate,
+		OmitMemo:            *noMemo,
+		FuzzyColumns:        *fuzzyColumns,
+		Logger:              logger,
+	}
+
+	// Start from the bank profile, the flags below override its settings
+	if *profileName != "" {
+		profile, ok := convert.LookupProfile(*profileName)
+		if !ok {
+			usageError("unknown profile %q", *profileName)
+		}
+		profile.Apply(&opts)
 	}
 
-	// Process each row
-	for {
-		row, err := reader.Read()
-		if err == io.EOF {
-			break
+	// Try the custom input date layouts before the profile's and built-in ones
+	if *dateFormats != "" {
+		var layouts []string
+		for _, layout := range strings.Split(*dateFormats, ",") {
+			layout = strings.TrimSpace(layout)
+			if time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC).Format(layout) == layout {
+				usageError("date format %q is not a Go time layout", layout)
+			}
+			layouts = append(layouts, layout)
 		}
+		opts.InputDateLayouts = append(layouts, opts.InputDateLayouts...)
+	}
+
+	// Show progress on stderr unless quiet
+	progress := &progressReporter{inPlace: isTerminal(os.Stderr)}
+	if logger.level >= levelInfo {
+		opts.Progress = progress.report
+	}
+
+	// Check if the custom header has every output field
+	if *header != "" {
+		opts.Header = strings.Split(*header, ",")
+		if err := convert.ValidateHeader(opts.Header, opts.Format); err != nil {
+			usageError("invalid header: %v", err)
+		}
+	}
+
+	// Resolve the output date format to a layout that includes a date
+	opts.DateLayout = *outDateFormat
+	if layout, ok := convert.DateFormats[strings.ToLower(*outDateFormat)]; ok {
+		opts.DateLayout = layout
+	}
+	if time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC).Format(opts.DateLayout) == opts.DateLayout {
+		usageError("output date format %q is neither iso, us, eu nor a Go time layout", *outDateFormat)
+	}
+
+	// Check if the column overrides are field=index pairs
+	for _, override := range columnOverrides {
+		field, index, found := strings.Cut(override, "=")
+		idx, err := strconv.Atoi(strings.TrimSpace(index))
+		if !found || err != nil {
+			usageError("column override %q must be field=index", override)
+		}
+		if opts.ColumnOverrides == nil {
+			opts.ColumnOverrides = map[string]int{}
+		}
+		opts.ColumnOverrides[strings.ToLower(strings.TrimSpace(field))] = idx
+	}
+
+	// Check if the delimiter is a single character or "tab"
+	if *delimiter != "" {
+		r, err := parseDelimiter(*delimiter)
 		if err != nil {
-			return fmt.Errorf("failed to read row: %w", err)
+			usageError("%v", err)
 		}
+		opts.Delimiter = r
+	}
 
-		// Extract and format date
-		date := formatDate(row[dateIdx])
+	// Check if the currency is a three-letter code
+	if *currency != "" {
+		if !regexp.MustCompile(`^[A-Za-z]{3}$`).MatchString(*currency) {
+			usageError("currency must be a three-letter ISO code, got %q", *currency)
+		}
+		opts.Currency = strings.ToUpper(*currency)
+	}
 
-		// Extract payee
-		payee := row[payeeIdx]
+	// Check if the input encoding is supported
+	*encoding = strings.ToLower(*encoding)
+	if !slices.Contains(convert.Encodings, *encoding) {
+		usageError("unsupported encoding %q", *encoding)
+	}
 
-		// Build memo from additional info and reference
-		var memoBuilder strings.Builder
+	// Check if the number of preamble lines is sensible
+	if *skipRows < 0 {
+		usageError("skip-rows must not be negative, got %d", *skipRows)
+	}
+	opts.SkipRows = *skipRows
+	opts.AutoHeader = *autoHeader
 
-		if memoIdx != -1 && row[memoIdx] != "" {
-			memoBuilder.WriteString(row[memoIdx])
+	// Compile the payment pattern if payments are dropped
+	if *dropPayments {
+		re, err := regexp.Compile(*paymentPattern)
+		if err != nil {
+			usageError("invalid payment pattern: %v", err)
 		}
+		opts.DropPayments = re
+	}
 
-		// Add reference if available
-		if referenceIdx != -1 && row[referenceIdx] != "" {
-			if memoBuilder.Len() > 0 {
-				memoBuilder.WriteString(" | ")
-			}
-			memoBuilder.WriteString("Ref: ")
-			memoBuilder.WriteString(row[referenceIdx])
+	// Ask for missing columns when someone is there to answer
+	if *interactive {
+		if isTerminal(os.Stdin) {
+			opts.ResolveColumns = promptColumns
+		} else {
+			logger.Warnf("-interactive is ignored as stdin is not a terminal")
 		}
+	}
 
-		// Add location information if available
-		var location strings.Builder
-		if locationIdx != -1 && row[locationIdx] != "" {
-			location.WriteString(row[locationIdx])
+	// Compile the payee filters
+	for _, filter := range []struct {
+		name    string
+		pattern string
+		re      **regexp.Regexp
+	}{{"include", *include, &opts.Include}, {"exclude", *exclude, &opts.Exclude}} {
+		if filter.pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(filter.pattern)
+		if err != nil {
+			usageError("invalid %s pattern: %v", filter.name, err)
 		}
-		if postcodeIdx != -1 && row[postcodeIdx] != "" {
-			if location.Len() > 0 {
-				location.WriteString(", ")
+		*filter.re = re
+	}
+
+	// Check if the memo separator survives CSV quoting
+	opts.MemoSeparator = strings.ReplaceAll(*memoSep, `\n`, "\n")
+	if err := convert.ValidateMemoSeparator(opts.MemoSeparator); err != nil {
+		usageError("%v", err)
+	}
+
+	// Check if the rounding mode is supported
+	switch *rounding {
+	case convert.RoundHalfEven, convert.RoundHalfUp, convert.RoundTruncate:
+		opts.Rounding = *rounding
+	default:
+		usageError("unsupported rounding mode %q", *rounding)
+	}
+
+	// Check if the sort order is supported
+	switch *sortOrder {
+	case convert.SortNone, convert.SortAsc, convert.SortDesc:
+		opts.Sort = *sortOrder
+	default:
+		usageError("unsupported sort order %q", *sortOrder)
+	}
+
+	// Check if the locale is supported
+	if *locale != "" && *locale != convert.LocaleEN && *locale != convert.LocaleNL && *locale != convert.LocaleAuto {
+		usageError("unsupported locale %q", *locale)
+	}
+	if *locale != "" {
+		opts.Locale = *locale
+	}
+
+	// Collect the values marking a debit in an indicator column
+	opts.DebitIndicators = []string{}
+	for _, value := range strings.Split(*debitValues, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			opts.DebitIndicators = append(opts.DebitIndicators, value)
+		}
+	}
+
+	// Check if the dedup key only uses known fields
+	if *dedup {
+		opts.Dedup = true
+		for _, field := range strings.Split(*dedupKey, ",") {
+			field = strings.ToLower(strings.TrimSpace(field))
+			if !slices.Contains(convert.DedupFields, field) {
+				usageError("unknown dedup key field %q", field)
 			}
-			location.WriteString(row[postcodeIdx])
+			opts.DedupKey = append(opts.DedupKey, field)
+		}
+	}
+
+	// Check if the memo parts are known
+	if *memoFields != "" {
+		for _, field := range strings.Split(*memoFields, ",") {
+			opts.MemoFields = append(opts.MemoFields, strings.ToLower(strings.TrimSpace(field)))
+		}
+		if err := convert.ValidateMemoFields(opts.MemoFields); err != nil {
+			usageError("%v", err)
 		}
-		if countryIdx != -1 && row[countryIdx] != "" {
-			if location.Len() > 0 {
-				location.WriteString(", ")
+	}
+
+	// Parse the date range if provided
+	if *fromDate != "" {
+		from, err := time.Parse(convert.DateLayout, *fromDate)
+		if err != nil {
+			usageError("invalid -from date %q, expected YYYY-MM-DD", *fromDate)
+		}
+		opts.From = from
+	}
+	if *toDate != "" {
+		to, err := time.Parse(convert.DateLayout, *toDate)
+		if err != nil {
+			usageError("invalid -to date %q, expected YYYY-MM-DD", *toDate)
+		}
+		opts.To = to
+	}
+
+	// Override the column names of the profile or the defaults from the
+	// environment, and those from the mapping file if provided
+	mapper := convert.CreateColumnMapper()
+	if opts.Mapper != nil {
+		mapper = *opts.Mapper
+	}
+	if applied := mapper.ApplyEnv(os.LookupEnv); len(applied) > 0 {
+		logger.Debugf("Column names from the environment: %s", strings.Join(applied, ", "))
+		opts.Mapper = &mapper
+	}
+	if *mappingFilePath != "" {
+		if err := mapper.LoadInto(*mappingFilePath); err != nil {
+			logger.Fatalf("Failed to load column mapping: %v", err)
+		}
+		opts.Mapper = &mapper
+	}
+
+	// Load the payee rules if provided
+	if *rulesFilePath != "" {
+		rules, err := convert.LoadPayeeRules(*rulesFilePath)
+		if err != nil {
+			logger.Fatalf("Failed to load payee rules: %v", err)
+		}
+		opts.PayeeRules = rules
+	}
+
+	// Load the sign overrides if provided
+	if *signRulesFilePath != "" {
+		rules, err := convert.LoadSignRules(*signRulesFilePath)
+		if err != nil {
+			logger.Fatalf("Failed to load sign rules: %v", err)
+		}
+		opts.SignRules = rules
+	}
+
+	// Open all input files before creating the output
+	inputFiles := make([]inputFile, 0, len(inputFilePaths))
+	addInput := func(path string, name string, reader io.Reader) {
+		// Decode the input to UTF-8
+		decoded, err := convert.NewDecoder(reader, *encoding)
+		if err != nil {
+			logger.Fatalf("Failed to open input file: %v", err)
+		}
+
+		// Read .tsv files as tab-separated unless a delimiter was forced
+		delimiter := opts.Delimiter
+		if delimiter == 0 && isTSV(name) {
+			delimiter = '\t'
+		}
+		inputFiles = append(inputFiles, inputFile{path: path, reader: decoded, delimiter: delimiter})
+	}
+	for _, inputFilePath := range inputFilePaths {
+		// Read every CSV in a zip archive, in archive order
+		if isZip(inputFilePath) {
+			archive, err := zip.OpenReader(inputFilePath)
+			if err != nil {
+				logger.Fatalf("Failed to open input file: %s: %v", inputFilePath, err)
 			}
-			location.WriteString(row[countryIdx])
+			defer archive.Close()
+
+			var members []string
+			for _, member := range archive.File {
+				if !isCSV(member.Name) {
+					logger.Debugf("Skipping %s in %s, not a CSV file", member.Name, inputFilePath)
+					continue
+				}
+				reader, err := member.Open()
+				if err != nil {
+					logger.Fatalf("Failed to open input file: %s: %v", inputFilePath, err)
+				}
+				defer reader.Close()
+				addInput(inputFilePath+":"+member.Name, member.Name, reader)
+				members = append(members, member.Name)
+			}
+			if len(members) == 0 {
+				logger.Fatalf("Failed to open input file: no CSV files in %s", inputFilePath)
+			}
+			logger.Debugf("Reading %s from %s", strings.Join(members, ", "), inputFilePath)
+			continue
 		}
 
-		// Add location to memo
-		if location.Len() > 0 {
-			if memoBuilder.Len() > 0 {
-				memoBuilder.WriteString(" | ")
+		reader, err := openInput(inputFilePath)
+		if err != nil {
+			logger.Fatalf("Failed to open input file: %v", err)
+		}
+		defer reader.Close()
+		addInput(displayPath(inputFilePath), inputFilePath, reader)
+	}
+
+	// List the input columns without converting anything
+	if *listColumns {
+		for _, input := range inputFiles {
+			inputOpts := opts
+			inputOpts.Delimiter = input.delimiter
+			summary, err := convert.ReadColumns(input.reader, inputOpts)
+			if err != nil {
+				logger.Fatalf("Failed to read columns: %s: %v", input.path, err)
 			}
-			memoBuilder.WriteString("Location: ")
-			memoBuilder.WriteString(location.String())
+			printColumns(input.path, summary)
 		}
+		return
+	}
 
-		memo := memoBuilder.String()
+	// Check the inputs without converting them
+	if *validate {
+		os.Exit(validateInputs(opts, inputFiles))
+	}
 
-		// Extract and invert amount
-		amount := invertAmount(row[amountIdx])
+	// Report what the conversion would do without creating the output file
+	if *dryRun {
+		var sample bytes.Buffer
+		summary, err := convertFiles(convert.NewSampleConverter(&sample, dryRunSampleRows, opts), inputFiles)
+		progress.done()
+		if err != nil {
+			logger.Fatalf("Failed to process CSV: %v", err)
+		}
+		printDryRun(summary, sample.String(), opts.KeepEmptyAmount)
+		return
+	}
 
-		// Write the YNAB row
-		err = writer.Write([]string{date, payee, memo, amount})
+	// Create the file collecting rows that don't convert cleanly
+	if *rejectsFilePath != "" {
+		rejectsFile, err := os.Create(*rejectsFilePath)
 		if err != nil {
-			return fmt.Errorf("failed to write row: %w", err)
+			logger.Fatalf("Failed to create rejects file: %v", err)
 		}
+		defer rejectsFile.Close()
+		opts.Rejects = rejectsFile
 	}
 
-	return nil
+	// Create the output file, or write to stdout and keep messages off it.
+	// Monthly files are only created once a row for the month comes up.
+	var err error
+	var converter *convert.Converter
+	var months *monthFiles
+	outputFile := os.Stdout
+	switch {
+	case *splitByMonth:
+		months = &monthFiles{dir: outputDir(*outputFilePath), ext: *outputFormat}
+		defer months.Close()
+		converter = convert.NewMonthlyConverter(months.open, opts)
+	case *outputFilePath == stdoutPath:
+		logger.info = os.Stderr
+		converter = convert.NewConverter(outputFile, opts)
+	case renameByDates:
+		// Write next to the final file and rename it once the dates are known
+		outputFile, err = os.CreateTemp(filepath.Dir(*outputFilePath), ".ynab_amex_*.tmp")
+		if err != nil {
+			logger.Fatalf("Failed to create output file: %v", err)
+		}
+		defer outputFile.Close()
+		converter = convert.NewConverter(outputFile, opts)
+	default:
+		// Ask before overwriting an existing file when run by hand
+		if !*appendOutput && !*force && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+			if info, err := os.Stat(*outputFilePath); err == nil && info.Mode().IsRegular() && !confirmOverwrite(*outputFilePath) {
+				fmt.Println("Aborted, output file left unchanged")
+				os.Exit(1)
+			}
+		}
+
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *appendOutput {
+			// Only a new or empty file needs a header
+			if info, err := os.Stat(*outputFilePath); err == nil && info.Size() > 0 {
+				opts.SkipHeader = true
+			}
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		outputFile, err = os.OpenFile(*outputFilePath, flags, 0o666)
+		if err != nil {
+			logger.Fatalf("Failed to create output file: %v", err)
+		}
+		defer outputFile.Close()
+		converter = convert.NewConverter(outputFile, opts)
+	}
+
+	// Process the CSV files in order, or concurrently into buffers that are
+	// written in order
+	var summary convert.Summary
+	if *jobs > 1 && len(inputFiles) > 1 {
+		summary, err = convertConcurrently(outputFile, opts, inputFiles, *jobs)
+	} else {
+		summary, err = convertFiles(converter, inputFiles)
+	}
+	progress.done()
+	if err != nil {
+		if renameByDates {
+			outputFile.Close()
+			os.Remove(outputFile.Name())
+		}
+		logger.Fatalf("Failed to process CSV: %v", err)
+	}
+
+	// Name the output after its date range
+	if renameByDates {
+		if summary.FirstDate != "" {
+			name := fmt.Sprintf("ynab_amex_%s_%s%s", summary.FirstDate, summary.LastDate, filepath.Ext(*outputFilePath))
+			*outputFilePath = filepath.Join(filepath.Dir(*outputFilePath), name)
+		}
+		if err := renameOutput(outputFile, *outputFilePath, *force); err != nil {
+			logger.Fatalf("Failed to create output file: %v", err)
+		}
+	}
+
+	// Report totals on stderr so stdout stays clean for piping
+	if logger.level >= levelInfo {
+		printTotals(os.Stderr, summary, opts.KeepEmptyAmount)
+	}
+
+	var convertedPaths []string
+	for _, input := range inputFiles {
+		convertedPaths = append(convertedPaths, input.path)
+	}
+	outputName := *outputFilePath
+	switch {
+	case months != nil && len(months.paths) == 0:
+		outputName = "no files (no transactions)"
+	case months != nil:
+		outputName = strings.Join(months.paths, ", ")
+	case outputName == stdoutPath:
+		outputName = "stdout"
+	}
+	if summary.Transactions == 0 {
+		logger.Warnf("0 transactions converted")
+	}
+	logger.Infof("Successfully converted %s to YNAB format. Output saved to %s", strings.Join(convertedPaths, ", "), outputName)
+
+	// Signal an imperfect conversion to scripts
+	if summary.HadParseErrors() {
+		if summary.Rejected > 0 {
+			logger.Warnf("some dates or amounts could not be converted and their rows were left out")
+		} else {
+			logger.Warnf("some dates or amounts could not be parsed and were passed through unchanged")
+		}
+		os.Exit(exitParseErrors)
+	}
+}
+
+// versionString returns the program version along with the VCS revision and
+// build date recorded by the Go toolchain, when available
+func versionString() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "amex2ynab " + version
+	}
+
+	var details []string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			details = append(details, "revision "+setting.Value)
+		case "vcs.time":
+			details = append(details, "built "+setting.Value)
+		case "vcs.modified":
+			if setting.Value == "true" {
+				details = append(details, "modified")
+			}
+		}
+	}
+	if len(details) == 0 {
+		return "amex2ynab " + version
+	}
+	return fmt.Sprintf("amex2ynab %s (%s)", version, strings.Join(details, ", "))
+}
+
+// flagWasSet reports whether the named flag was passed on the command line
+func flagWasSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// usageError prints a command line error and the usage to stderr and exits
+func usageError(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	flag.Usage()
+	os.Exit(1)
 }
 
-func createColumnMapper() ColumnMapper {
-	return ColumnMapper{
-		DateColumns:      []string{"Datum"},
-		PayeeColumns:     []string{"Omschrijving"},
-		AmountColumns:    []string{"Bedrag"},
-		MemoColumns:      []string{"Aanvullende informatie"},
-		ReferenceColumns: []string{"Referentie"},
-		LocationColumns:  []string{"Plaats"},
-		PostcodeColumns:  []string{"Postcode"},
-		CountryColumns:   []string{"Land"},
+// stringList is a flag that collects values from repeated and comma-separated uses
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
 	}
+	return nil
 }
 
-func findColumnIndex(header []string, possibleNames []string) int {
-	for i, h := range header {
-		h = strings.TrimSpace(strings.ToLower(h))
-		for _, name := range possibleNames {
-			if strings.TrimSpace(strings.ToLower(name)) == h {
-				return i
+// promptColumns lists the header on stderr and asks which column holds each
+// missing field, keyed by lowercase field name as ColumnOverrides expects
+func promptColumns(header []string, missing []string) (map[string]int, error) {
+	fmt.Fprintf(os.Stderr, "Couldn't find a column for %s. The input has these columns:\n", strings.Join(missing, ", "))
+	for i, name := range header {
+		fmt.Fprintf(os.Stderr, "  %d: %s\n", i, name)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	picks := map[string]int{}
+	for _, field := range missing {
+		for {
+			fmt.Fprintf(os.Stderr, "Column number for %s: ", field)
+			answer, err := reader.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("no column picked for %s", field)
+			}
+			idx, err := strconv.Atoi(strings.TrimSpace(answer))
+			if err == nil && idx >= 0 && idx < len(header) {
+				picks[strings.ToLower(field)] = idx
+				break
 			}
+			fmt.Fprintf(os.Stderr, "Enter a number from 0 to %d\n", len(header)-1)
 		}
 	}
-	return -1
+	return picks, nil
 }
 
-func formatDate(dateStr string) string {
-	// Try different date formats
-	formats := []string{
-		"01/02/2006", // MM/DD/YYYY
-		"02/01/2006", // DD/MM/YYYY
+// renameOutput closes the temporary output file and moves it to path, asking
+// before overwriting an existing file when run by hand. The temporary file is
+// removed if it can't be moved.
+func renameOutput(output *os.File, path string, force bool) error {
+	if err := output.Close(); err != nil {
+		os.Remove(output.Name())
+		return err
 	}
+	if !force && isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && !confirmOverwrite(path) {
+			os.Remove(output.Name())
+			return fmt.Errorf("%s already exists and was left unchanged", path)
+		}
+	}
+	if err := os.Rename(output.Name(), path); err != nil {
+		os.Remove(output.Name())
+		return err
+	}
+	return nil
+}
+
+// defaultOutputDir returns ~/Desktop, or the working directory on systems
+// without one such as headless servers
+func defaultOutputDir() string {
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		desktop := filepath.Join(homeDir, "Desktop")
+		if info, err := os.Stat(desktop); err == nil && info.IsDir() {
+			return desktop
+		}
+	}
+	return "."
+}
+
+// outputDir returns the directory -split-by-month writes to: the output path
+// itself if it is a directory, otherwise the directory containing it
+func outputDir(path string) string {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return path
+	}
+	return filepath.Dir(path)
+}
+
+// monthFiles creates the per-month output files of -split-by-month
+type monthFiles struct {
+	dir string
+	ext string
+	// paths lists the files created so far, in the order of their first row
+	paths []string
+	files []*os.File
+}
+
+// open creates the output file for a month, named like ynab_amex_2024-03.csv
+func (m *monthFiles) open(month string) (io.Writer, error) {
+	path := filepath.Join(m.dir, "ynab_amex_"+month+"."+m.ext)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	m.paths = append(m.paths, path)
+	m.files = append(m.files, file)
+	return file, nil
+}
 
-	for _, format := range formats {
-		if t, err := time.Parse(format, dateStr); err == nil {
-			// Format as YYYY-MM-DD (year-month-day)
-			return fmt.Sprintf("%04d-%02d-%02d", t.Year(), t.Month(), t.Day())
+// Close closes every file created so far
+func (m *monthFiles) Close() error {
+	var err error
+	for _, file := range m.files {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
 		}
 	}
+	return err
+}
+
+// inputFile is an opened input along with how it should be read
+type inputFile struct {
+	path   string
+	reader io.Reader
+	// delimiter forces the field separator, zero means auto-detect
+	delimiter rune
+}
+
+// convertFiles runs each input through converter in order, naming the file
+// that failed in the returned error
+func convertFiles(converter *convert.Converter, inputs []inputFile) (convert.Summary, error) {
+	for _, input := range inputs {
+		if err := converter.ConvertDelimited(input.reader, input.delimiter); err != nil {
+			return converter.Summary(), fmt.Errorf("%s: %w", input.path, err)
+		}
+	}
+	return converter.Summary(), converter.Close()
+}
+
+// convertConcurrently converts each input with its own Converter on up to
+// jobs goroutines, buffering every output. Only once all inputs converted
+// without errors are the buffers written to output, in input order, so a
+// failure leaves no partial output.
+func convertConcurrently(output io.Writer, opts convert.Options, inputs []inputFile, jobs int) (convert.Summary, error) {
+	type result struct {
+		output  bytes.Buffer
+		rejects bytes.Buffer
+		summary convert.Summary
+		err     error
+	}
+	results := make([]result, len(inputs))
+
+	slots := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, input := range inputs {
+		wg.Add(1)
+		go func(i int, input inputFile) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			res := &results[i]
+			inputOpts := opts
+			inputOpts.Progress = nil
+			// Only the first input writes the header
+			inputOpts.SkipHeader = opts.SkipHeader || i > 0
+			if opts.Rejects != nil {
+				inputOpts.Rejects = &res.rejects
+			}
+
+			converter := convert.NewConverter(&res.output, inputOpts)
+			if err := converter.ConvertDelimited(input.reader, input.delimiter); err != nil {
+				res.err = fmt.Errorf("%s: %w", input.path, err)
+				return
+			}
+			res.err = converter.Close()
+			res.summary = converter.Summary()
+		}(i, input)
+	}
+	wg.Wait()
+
+	var summary convert.Summary
+	for i := range results {
+		if results[i].err != nil {
+			return summary, results[i].err
+		}
+	}
+	var rejectsHeader []string
+	for i := range results {
+		if _, err := results[i].output.WriteTo(output); err != nil {
+			return summary, err
+		}
+		if opts.Rejects != nil && results[i].rejects.Len() > 0 {
+			// Each input's rejects start with its header, which a sequential
+			// run only repeats when it differs from the one before
+			rejects := results[i].rejects.Bytes()
+			header := results[i].summary.Header
+			if slices.Equal(header, rejectsHeader) {
+				rejects = bytes.TrimPrefix(rejects, csvRecord(header))
+			}
+			rejectsHeader = header
+			if _, err := opts.Rejects.Write(rejects); err != nil {
+				return summary, err
+			}
+		}
+		summary.Merge(results[i].summary)
+	}
+	return summary, nil
+}
+
+// csvRecord returns record encoded as a CSV line
+func csvRecord(record []string) []byte {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(record)
+	writer.Flush()
+	return buf.Bytes()
+}
+
+// isTSV reports whether path has a .tsv extension, possibly gzipped
+func isTSV(path string) bool {
+	if strings.EqualFold(filepath.Ext(path), ".gz") {
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+	return strings.EqualFold(filepath.Ext(path), ".tsv")
+}
+
+// isZip reports whether path has a .zip extension
+func isZip(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".zip")
+}
+
+// isCSV reports whether a zip archive member is a CSV or TSV file, skipping
+// directories and the metadata macOS adds to archives
+func isCSV(name string) bool {
+	if strings.HasSuffix(name, "/") || strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(filepath.Base(name), "._") {
+		return false
+	}
+	ext := filepath.Ext(name)
+	return strings.EqualFold(ext, ".csv") || strings.EqualFold(ext, ".tsv")
+}
+
+// parseDelimiter turns the -delimiter flag into a rune, accepting "tab" and
+// "\t" as names for the tab character
+func parseDelimiter(value string) (rune, error) {
+	switch strings.ToLower(value) {
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	runes := []rune(value)
+	if len(runes) != 1 {
+		return 0, fmt.Errorf("delimiter must be a single character or \"tab\", got %q", value)
+	}
+	return runes[0], nil
+}
+
+// gzipMagic is the header every gzip stream starts with
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// stdinPath is the input path that reads from stdin
+const stdinPath = "-"
+
+// stdoutPath is the output path that writes to stdout
+const stdoutPath = "-"
+
+// stdinIsPiped reports whether stdin is a pipe or file rather than a terminal
+func stdinIsPiped() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice == 0
+}
+
+// isTerminal reports whether f is a terminal rather than a pipe or file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
 
-	// If no format matches, return the original string
-	// This is not ideal but allows the process to continue
-	return dateStr
+// confirmOverwrite asks whether an existing output file may be overwritten,
+// accepting only y or yes
+func confirmOverwrite(path string) bool {
+	fmt.Printf("%s already exists. Overwrite? [y/N] ", path)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
 }
 
-func invertAmount(amountStr string) string {
-	// Remove currency symbols, spaces, and handle European decimal format
-	re := regexp.MustCompile(`[^\d.,\-]`)
-	cleanAmount := re.ReplaceAllString(amountStr, "")
+// displayPath returns the name an input path is reported by
+func displayPath(path string) string {
+	if path == stdinPath {
+		return "stdin"
+	}
+	return path
+}
 
-	// Replace comma with dot if European format
-	if strings.Count(cleanAmount, ",") == 1 && strings.Count(cleanAmount, ".") <= 1 {
-		// If there are both commas and dots, assume European format with thousands separator
-		if strings.Count(cleanAmount, ".") == 1 {
-			cleanAmount = strings.ReplaceAll(cleanAmount, ".", "")
+// openInput opens the file at path for reading, or stdin for stdinPath. Files
+// with a .gz extension or starting with the gzip magic number are
+// decompressed transparently.
+func openInput(path string) (io.ReadCloser, error) {
+	file := os.Stdin
+	if path != stdinPath {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			return nil, err
 		}
-		cleanAmount = strings.Replace(cleanAmount, ",", ".", 1)
 	}
 
-	// Parse the amount
-	amount, err := strconv.ParseFloat(cleanAmount, 64)
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if !strings.EqualFold(filepath.Ext(path), ".gz") && !bytes.Equal(magic, gzipMagic) {
+		return &layeredReader{Reader: buffered, closers: []io.Closer{file}}, nil
+	}
+
+	gzipReader, err := gzip.NewReader(buffered)
 	if err != nil {
-		// Return original if parsing fails
-		return amountStr
+		file.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return &layeredReader{Reader: gzipReader, closers: []io.Closer{gzipReader, file}}, nil
+}
+
+// layeredReader reads from the outermost layer of a stack of readers and
+// closes every layer, outermost first
+type layeredReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *layeredReader) Close() error {
+	var firstErr error
+	for _, closer := range r.closers {
+		if err := closer.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
+
+// validateLines is the number of line numbers -validate lists per problem
+const validateLines = 10
+
+// validateInputs converts every input without writing any output and prints
+// the columns that weren't found and the rows that don't convert cleanly. It
+// returns the exit code: 1 for an input that can't be converted at all,
+// exitParseErrors for problem rows and 0 otherwise.
+func validateInputs(opts convert.Options, inputs []inputFile) int {
+	// Convert every row and report problems only once, in the report
+	opts.Strict = false
+	opts.Rejects = nil
+	opts.Limit = 0
+	opts.Progress = nil
+	opts.Logger = &leveledLogger{level: levelError, info: os.Stdout, diag: os.Stderr}
+
+	code := 0
+	for _, input := range inputs {
+		lines := map[string][]int{}
+		inputOpts := opts
+		inputOpts.Delimiter = input.delimiter
+		inputOpts.OnProblem = func(p convert.Problem) {
+			lines[p.Kind] = append(lines[p.Kind], p.Line)
+		}
+		converter := convert.NewConverter(io.Discard, inputOpts)
+		err := converter.ConvertDelimited(input.reader, input.delimiter)
+
+		fmt.Printf("%s:\n", input.path)
+		if err != nil {
+			fmt.Printf("  Error: %v\n", err)
+			code = 1
+			continue
+		}
 
-	// Invert the amount
-	invertedAmount := -amount
+		var missing []string
+		for _, match := range converter.Summary().Columns {
+			if match.Index == -1 {
+				missing = append(missing, match.Field)
+			}
+		}
+		if len(missing) > 0 {
+			fmt.Printf("  Optional columns not found: %s\n", strings.Join(missing, ", "))
+		}
 
-	// Format the result with 2 decimal places
-	return fmt.Sprintf("%.2f", invertedAmount)
+		for _, kind := range []string{convert.ProblemFieldCount, convert.ProblemDate, convert.ProblemAmount} {
+			if len(lines[kind]) == 0 {
+				continue
+			}
+			listed := make([]string, 0, validateLines+1)
+			for _, line := range lines[kind][:min(len(lines[kind]), validateLines)] {
+				listed = append(listed, strconv.Itoa(line))
+			}
+			if len(lines[kind]) > validateLines {
+				listed = append(listed, "...")
+			}
+			fmt.Printf("  Rows with a bad %s: %d (lines %s)\n", kind, len(lines[kind]), strings.Join(listed, ", "))
+			if code == 0 {
+				code = exitParseErrors
+			}
+		}
+		if len(lines) == 0 {
+			fmt.Println("  No problem rows found")
+		}
+	}
+	return code
+}
+
+// dryRunSampleRows is the number of converted rows shown by -dry-run
+const dryRunSampleRows = 5
+
+// printDryRun reports the detected columns, totals and sample output to stdout
+func printDryRun(summary convert.Summary, sample string, keptEmpty bool) {
+	fmt.Println("Dry run: no output file written")
+	fmt.Println("Detected columns:")
+	for _, match := range summary.Columns {
+		if match.Index == -1 {
+			fmt.Printf("  %s: not found\n", match.Field)
+			continue
+		}
+		fmt.Printf("  %s: column %d (%s)\n", match.Field, match.Index, summary.Header[match.Index])
+	}
+	printTotals(os.Stdout, summary, keptEmpty)
+	fmt.Printf("First %d converted rows:\n", dryRunSampleRows)
+	fmt.Print(sample)
+}
+
+// printColumns prints every column of an input header with its index and
+// the YNAB field it maps to, if any
+func printColumns(path string, summary convert.Summary) {
+	fields := map[int]string{}
+	for _, match := range summary.Columns {
+		if match.Index != -1 {
+			fields[match.Index] = match.Field
+		}
+	}
+
+	fmt.Printf("%s:\n", path)
+	for i, name := range summary.Header {
+		if field, ok := fields[i]; ok {
+			fmt.Printf("  %d: %s -> %s\n", i, name, field)
+		} else {
+			fmt.Printf("  %d: %s\n", i, name)
+		}
+	}
+}
+
+// printTotals writes the transaction count and amount totals of a conversion
+// to w. keptEmpty tells whether rows without an amount were kept.
+func printTotals(w io.Writer, summary convert.Summary, keptEmpty bool) {
+	fmt.Fprintf(w, "Transactions: %d\n", summary.Transactions)
+	fmt.Fprintf(w, "Total inflow: %.2f\n", summary.Inflow)
+	fmt.Fprintf(w, "Total outflow: %.2f\n", summary.Outflow)
+	fmt.Fprintf(w, "Net total: %.2f\n", summary.Net())
+	fmt.Fprintf(w, "Skipped: %d (unparseable amounts, not included in the totals)\n", summary.Skipped)
+	if summary.Duplicates > 0 {
+		fmt.Fprintf(w, "Duplicates dropped: %d\n", summary.Duplicates)
+	}
+	if summary.DateErrors > 0 {
+		fmt.Fprintf(w, "Unparseable dates: %d\n", summary.DateErrors)
+	}
+	if summary.Malformed > 0 {
+		fmt.Fprintf(w, "Malformed rows skipped: %d\n", summary.Malformed)
+	}
+	if summary.Payments > 0 {
+		fmt.Fprintf(w, "Payments dropped: %d\n", summary.Payments)
+	}
+	if summary.Pending > 0 {
+		fmt.Fprintf(w, "Pending dropped: %d\n", summary.Pending)
+	}
+	if summary.Zero > 0 {
+		fmt.Fprintf(w, "Zero amounts dropped: %d\n", summary.Zero)
+	}
+	if summary.Filtered > 0 {
+		fmt.Fprintf(w, "Filtered out by payee: %d\n", summary.Filtered)
+	}
+	if summary.EmptyAmounts > 0 {
+		if keptEmpty {
+			fmt.Fprintf(w, "Empty amounts kept blank: %d\n", summary.EmptyAmounts)
+		} else {
+			fmt.Fprintf(w, "Empty amounts dropped: %d\n", summary.EmptyAmounts)
+		}
+	}
+	if summary.Rejected > 0 {
+		fmt.Fprintf(w, "Rejected rows left out: %d\n", summary.Rejected)
+	}
+}
+
+// progressReporter prints the running row count of a conversion to stderr,
+// updating a single line in place when stderr is a terminal
+type progressReporter struct {
+	inPlace bool
+	printed bool
+}
+
+func (p *progressReporter) report(rows int) {
+	if p.inPlace {
+		fmt.Fprintf(os.Stderr, "\rProcessed %d rows", rows)
+	} else {
+		fmt.Fprintf(os.Stderr, "Processed %d rows\n", rows)
+	}
+	p.printed = true
+}
+
+// done ends the progress line so later messages start on their own line
+func (p *progressReporter) done() {
+	if p.inPlace && p.printed {
+		fmt.Fprintln(os.Stderr)
+	}
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexanderjeurissen/amex2ynab/convert"
+)
+
+// convertFile opens path with openInput and converts it with default options
+func convertFile(t *testing.T, path string) string {
+	t.Helper()
+	input, err := openInput(path)
+	if err != nil {
+		t.Fatalf("openInput(%q): %v", path, err)
+	}
+	defer input.Close()
+
+	var output bytes.Buffer
+	if _, err := convert.ProcessCSV(input, &output, convert.Options{}); err != nil {
+		t.Fatalf("ProcessCSV(%q): %v", path, err)
+	}
+	return output.String()
+}
+
+func TestOpenInputGzip(t *testing.T) {
+	want := "Date,Payee,Memo,Amount\n2024-03-15,ALBERT HEIJN,,-12.34\n2024-03-16,REFUND,,5.00\n"
+	if got := convertFile(t, filepath.Join("testdata", "dutch.csv.gz")); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	// Without the .gz extension the gzip magic number gives it away
+	data, err := os.ReadFile(filepath.Join("testdata", "dutch.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := filepath.Join(t.TempDir(), "dutch.csv")
+	if err := os.WriteFile(renamed, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := convertFile(t, renamed); got != want {
+		t.Errorf("output without .gz extension = %q, want %q", got, want)
+	}
+}
+
+func TestConvertConcurrentlyMatchesSequential(t *testing.T) {
+	bad := "Datum,Omschrijving,Bedrag\n03/15/2024,A,\"12,34\"\n03/16/2024,B,abc\n"
+	other := "Datum,Bedrag,Omschrijving\n03/17/2024,xyz,C\n"
+	contents := []string{bad, bad, other, bad, bad}
+	inputs := func() []inputFile {
+		var inputs []inputFile
+		for i, content := range contents {
+			inputs = append(inputs, inputFile{path: fmt.Sprintf("input%d.csv", i), reader: strings.NewReader(content)})
+		}
+		return inputs
+	}
+
+	var sequential, sequentialRejects bytes.Buffer
+	opts := convert.Options{Rejects: &sequentialRejects}
+	if _, err := convertFiles(convert.NewConverter(&sequential, opts), inputs()); err != nil {
+		t.Fatal(err)
+	}
+
+	var concurrent, concurrentRejects bytes.Buffer
+	opts.Rejects = &concurrentRejects
+	if _, err := convertConcurrently(&concurrent, opts, inputs(), 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if concurrent.String() != sequential.String() {
+		t.Errorf("output = %q, want %q", concurrent.String(), sequential.String())
+	}
+	if concurrentRejects.String() != sequentialRejects.String() {
+		t.Errorf("rejects = %q, want %q", concurrentRejects.String(), sequentialRejects.String())
+	}
+}